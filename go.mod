@@ -7,6 +7,7 @@ toolchain go1.24.1
 require (
 	github.com/duckdb/duckdb-go/v2 v2.5.1
 	github.com/joho/godotenv v1.5.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
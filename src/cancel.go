@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Statuses recorded for sync runs.
+const (
+	statusRunning   = "running"
+	statusSuccess   = "success"
+	statusFailed    = "failed"
+	statusCancelled = "cancelled"
+)
+
+// rootCtx is cancelled on SIGINT or SIGTERM. All database work derives its
+// context from it, so a signal interrupts the running DuckDB query.
+var rootCtx = context.Background()
+
+// stopCtx is cancelled when the daemon is asked to shut down. Unlike rootCtx
+// it leaves the running query alone and only keeps retries from starting.
+var stopCtx = context.Background()
+
+// timeout limits each migration, rollback and sync file; 0 means no limit.
+// A -- TIMEOUT directive in the file overrides it.
+var timeout time.Duration
+
+// timeoutDirectiveRe matches a per-file timeout such as -- TIMEOUT 10m.
+var timeoutDirectiveRe = regexp.MustCompile(`(?im)^[ \t]*--[ \t]*TIMEOUT[ \t]+(\S+)[ \t]*$`)
+
+// fileContext returns the context to run one migration or sync file in,
+// bounded by its -- TIMEOUT directive or the --timeout flag.
+func fileContext(content string) (context.Context, context.CancelFunc, error) {
+	d := timeout
+	if m := timeoutDirectiveRe.FindStringSubmatch(content); m != nil {
+		var err error
+		if d, err = time.ParseDuration(m[1]); err != nil || d <= 0 {
+			return nil, nil, fmt.Errorf("invalid TIMEOUT directive %q", m[1])
+		}
+	}
+	if d > 0 {
+		ctx, cancel := context.WithTimeout(rootCtx, d)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithCancel(rootCtx)
+	return ctx, cancel, nil
+}
+
+// cancelReason explains why ctx ended: "timed out" or "cancelled". It
+// returns "" if ctx is still live, i.e. the error was the query's own.
+func cancelReason(ctx context.Context) string {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "timed out"
+	case ctx.Err() != nil:
+		return "cancelled"
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// slowQuery runs far longer than any test timeout.
+const slowQuery = "CREATE TABLE slow AS SELECT count(*) AS n FROM range(100000000000) a, range(10) b;"
+
+func TestFileContext(t *testing.T) {
+	prev := timeout
+	t.Cleanup(func() { timeout = prev })
+	timeout = time.Hour
+
+	ctx, cancel, err := fileContext("-- TIMEOUT 90s\n-- MIGRATE\nSELECT 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline, ok := ctx.Deadline()
+	cancel()
+	if !ok || time.Until(deadline) > 90*time.Second {
+		t.Errorf("-- TIMEOUT should override --timeout, deadline %v", deadline)
+	}
+
+	if _, _, err = fileContext("-- TIMEOUT soon\n"); err == nil {
+		t.Error("expected an error for an invalid TIMEOUT directive")
+	}
+}
+
+func TestApplyMigrations_Timeout(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_timeout.db", dir)
+	initialize()
+
+	content := "-- TIMEOUT 200ms\n-- MIGRATE\n" + slowQuery + "\n-- ROLLBACK\nDROP TABLE slow;"
+	if err := os.WriteFile(filepath.Join(dir, "001_slow.sql"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if code := applyMigrations(); code != exitCancelled {
+		t.Fatalf("apply: want %d, got %d", exitCancelled, code)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("the query was not interrupted, apply took %v", elapsed)
+	}
+	if code := showStatus(true); code != exitPending {
+		t.Errorf("a timed out migration should stay pending: want %d, got %d", exitPending, code)
+	}
+}
+
+func TestSyncMigration_CancelledIsRecorded(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_cancel_sync.db", dir)
+	initialize()
+	setOutputFormat(t, formatJSON)
+	if err := os.WriteFile(filepath.Join(dir, "slow_sync.sql"), []byte(slowQuery), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rootCtx = ctx
+	t.Cleanup(func() { rootCtx = context.Background() })
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	var code int
+	captureStdout(t, func() { code = syncMigration("slow_sync") })
+	if code != exitCancelled {
+		t.Fatalf("sync: want %d, got %d", exitCancelled, code)
+	}
+
+	rootCtx = context.Background()
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var status string
+	if err = db.QueryRow("SELECT status FROM attached_db.sync WHERE filename = 'slow_sync'").Scan(&status); err != nil {
+		t.Fatalf("cancelled run not recorded: %v", err)
+	}
+	if status != statusCancelled {
+		t.Errorf("status: want %q, got %q", statusCancelled, status)
+	}
+	var n int
+	if err = db.QueryRow("SELECT count(*) FROM duckdb_tables() WHERE table_name = 'slow'").Scan(&n); err != nil || n != 0 {
+		t.Errorf("the cancelled sync's table should be rolled back: n=%d err=%v", n, err)
+	}
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// What a command's positional arguments complete to in shell completion.
+const (
+	completeMigrations = "migrations"
+	completeSync       = "sync"
+	completeSyncGroups = "sync-groups"
+	completeCommands   = "commands"
+	completeShells     = "shells"
+	completeConfig     = "config"
+)
+
+// command is a duckdbm subcommand.
+type command struct {
+	Name    string
+	Args    string // positional arguments in usage, e.g. "<name>"
+	Summary string
+	// Complete is what positional arguments complete to, e.g.
+	// completeMigrations. FlagComplete does the same for flag values.
+	Complete     string
+	FlagComplete map[string]string
+	// Local commands do not load .env, e.g. help and completion.
+	Local  bool
+	Hidden bool
+	// Setup registers the command's flags on fs and returns the function that
+	// runs it with the positional arguments.
+	Setup func(fs *flag.FlagSet) func(args []string) int
+}
+
+// commands lists every subcommand in the order shown by help. It is filled
+// in init because help and completion refer to it.
+var commands []command
+
+// activeConfig is the configuration resolved for the running command.
+var activeConfig *resolvedConfig
+
+// Global flag values other than dbFile, configFile and envName.
+var (
+	logLevel  = "info"
+	logFormat = logFormatText
+	logFile   = ""
+)
+
+func init() {
+	commands = []command{
+		{
+			Name: "init", Summary: "Create the migrations and sync tables",
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				check := fs.Bool("check", false, "Exit with 6 if init would create or upgrade anything, without changing the database")
+				return func([]string) int {
+					if *check {
+						return checkInitialized()
+					}
+					return initialize()
+				}
+			},
+		},
+		{
+			Name: "create", Args: "<name>", Summary: "Create a new migration file",
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				return func(args []string) int {
+					if len(args) < 1 {
+						fmt.Fprintln(os.Stderr, "Input migration name.")
+						return exitUsage
+					}
+					return createMigration(args[0])
+				}
+			},
+		},
+		{
+			Name: "apply", Summary: "Apply pending migrations",
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				dump := fs.Bool("dump-schema", false, "Write the resulting schema after applying")
+				schemaFile := fs.String("schema-file", "schema.sql", "Schema dump file")
+				fs.BoolVar(&allowDestructive, "allow-destructive", false, "Apply migrations that drop or truncate tables or columns")
+				return func([]string) int {
+					if code := applyMigrations(); code != exitOK || !*dump {
+						return code
+					}
+					return dumpSchema(*schemaFile, false)
+				}
+			},
+		},
+		{
+			Name: "rollback", Args: "[count]", Summary: "Roll back the last migrations",
+			FlagComplete: map[string]string{"to": completeMigrations},
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				to := fs.String("to", "", "Roll back every migration applied after this one")
+				return func(args []string) int {
+					if *to != "" {
+						if len(args) > 0 {
+							fmt.Fprintln(os.Stderr, "Use either a rollback count or --to, not both.")
+							return exitUsage
+						}
+						return rollbackTo(*to)
+					}
+					n := 1
+					if len(args) > 0 {
+						var err error
+						n, err = strconv.Atoi(args[0])
+						if err != nil || n <= 0 {
+							fmt.Fprintln(os.Stderr, "Please provide a valid positive number for rollback count.")
+							return exitUsage
+						}
+					}
+					return rollbackLast(n)
+				}
+			},
+		},
+		{
+			Name: "list", Args: "[migrations|sync] [limit]", Summary: "List applied migrations or sync runs",
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				formatFlag(fs)
+				return func(args []string) int {
+					kind := "migrations"
+					if len(args) > 0 {
+						kind, args = args[0], args[1:]
+					}
+					if _, ok := trackingTableFor(kind); !ok {
+						fmt.Fprintf(os.Stderr, "Unknown table %q; use migrations or sync.\n", kind)
+						return exitUsage
+					}
+					limit, ok := limitArg(args, "list [migrations|sync] [limit]")
+					if !ok {
+						return exitUsage
+					}
+					return listAppliedMigrations(kind, limit)
+				}
+			},
+		},
+		{
+			Name: "status", Summary: "Show applied and pending migrations",
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				formatFlag(fs)
+				check := fs.Bool("check", false, "Exit with 5 if applied migration files are missing, or 6 if migrations are pending")
+				return func([]string) int { return showStatus(*check) }
+			},
+		},
+		{
+			Name: "history", Args: "[limit]", Summary: "List recent sync runs",
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				formatFlag(fs)
+				return func(args []string) int {
+					limit, ok := limitArg(args, "history [limit]")
+					if !ok {
+						return exitUsage
+					}
+					return listAppliedMigrations("sync", limit)
+				}
+			},
+		},
+		{
+			Name: "sync", Args: "[name|glob...] | history [name] [limit] | state show|set|reset <name>", Summary: "Run sync files without recording them as migrations, or show their history",
+			Complete:     completeSync,
+			FlagComplete: map[string]string{"group": completeSyncGroups},
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				formatFlag(fs)
+				var sel syncSelection
+				fs.BoolVar(&sel.All, "all", false, "Run every file in the sync directory")
+				fs.Func("group", "Run the files in this group, from -- GROUP headers or sync_groups (repeatable)", func(v string) error {
+					sel.Groups = append(sel.Groups, v)
+					return nil
+				})
+				return func(args []string) int {
+					if len(args) > 0 && args[0] == "history" {
+						return showSyncHistory(args[1:])
+					}
+					if len(args) > 0 && args[0] == "state" {
+						return syncStateCommand(args[1:])
+					}
+					sel.Patterns = args
+					if !sel.All && len(sel.Groups) == 0 && len(args) == 0 {
+						fmt.Fprintln(os.Stderr, "Please provide the name of the sync file, a glob, --group or --all.")
+						return exitUsage
+					}
+					names, err := selectSyncFiles(sel)
+					if err != nil {
+						logger.Error("Failed to select sync files", "error", err)
+						return exitUsage
+					}
+					return syncFiles(names)
+				}
+			},
+		},
+		{
+			Name: "daemon", Summary: "Run scheduled sync files until stopped",
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				formatFlag(fs)
+				list := fs.Bool("list", false, "List the scheduled sync files and their next run, then exit")
+				timeout := fs.Duration("shutdown-timeout", 0, "On shutdown, cancel a running sync after this long (0 waits for it to finish)")
+				return func(args []string) int {
+					if len(args) > 0 {
+						fmt.Fprintln(os.Stderr, "daemon takes no arguments.")
+						return exitUsage
+					}
+					return runDaemon(*list, *timeout)
+				}
+			},
+		},
+		{
+			Name: "render", Args: "<file>", Summary: "Print a migration or sync file with macros substituted",
+			Complete: completeMigrations,
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				return func(args []string) int {
+					if len(args) < 1 {
+						fmt.Fprintln(os.Stderr, "Please provide the migration file to render.")
+						return exitUsage
+					}
+					return renderMigration(args[0])
+				}
+			},
+		},
+		{
+			Name: "validate", Args: "[pattern]", Summary: "Check migrations without touching the database",
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				formatFlag(fs)
+				apply := fs.Bool("apply", false, "Apply all migrations to an in-memory database to catch binder and catalog errors")
+				roundtrip := fs.Bool("roundtrip", false, "Apply, roll back and re-apply each migration in an in-memory database")
+				fs.Var(validateReports, "report", "Write a report, e.g. junit=validate.xml")
+				return func(args []string) int {
+					args = append([]string{"validate"}, args...)
+					var checks []string
+					if *apply {
+						checks = append(checks, checkApply)
+					}
+					if *roundtrip {
+						checks = append(checks, checkRoundtrip)
+					}
+					if len(checks) > 0 {
+						return runValidation(args, checks, []string{migrationsDir})
+					}
+					dirs := []string{migrationsDir}
+					if info, err := os.Stat(syncDirectory()); err == nil && info.IsDir() {
+						dirs = append(dirs, syncDirectory())
+					}
+					return validateMigrations(args, dirs...)
+				}
+			},
+		},
+		{
+			Name: "lint", Args: "[pattern]", Summary: "Check migrations for destructive changes and risky patterns",
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				severities := severityFlag{}
+				fs.Var(severities, "severity", "Override a rule's severity, e.g. drop-table=warning (repeatable)")
+				fs.Var(lintReports, "report", "Write a report, e.g. sarif=lint.sarif")
+				return func(args []string) int {
+					target := ""
+					if len(args) > 0 {
+						target = args[0]
+					}
+					return runLint(target, severities)
+				}
+			},
+		},
+		{
+			Name: "squash", Summary: "Replace old migrations with a baseline",
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				upTo := fs.Int("up-to", 0, "Squash migrations numbered up to and including N")
+				name := fs.String("name", "squashed_baseline", "Name of the squashed migration")
+				archive := fs.String("archive", "", "Directory to move squashed originals to (default <migrations_dir>/archive)")
+				return func([]string) int {
+					if *upTo <= 0 {
+						fmt.Fprintln(os.Stderr, "Please provide --up-to with a positive migration number.")
+						return exitUsage
+					}
+					if *archive == "" {
+						*archive = filepath.Join(migrationsDir, archiveDirName)
+					}
+					return squashMigrations(*upTo, *name, *archive)
+				}
+			},
+		},
+		{
+			Name: "dump-schema", Summary: "Write the database schema to a file",
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				out := fs.String("out", "schema.sql", "Schema dump file")
+				check := fs.Bool("check", false, "Exit with 5 if the dump file is stale instead of writing it")
+				return func([]string) int { return dumpSchema(*out, *check) }
+			},
+		},
+		{
+			Name: "diff", Summary: "Generate a migration from the difference to a schema file",
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				schemaFile := fs.String("schema", "schema.sql", "Desired-state schema file")
+				fromMigrations := fs.Bool("from-migrations", false, "Compare against all migrations applied to an in-memory database")
+				name := fs.String("name", "schema_diff", "Name of the generated migration")
+				dryRun := fs.Bool("dry-run", false, "Print the migration instead of writing it")
+				check := fs.Bool("check", false, "Exit with 5 if the schema differs instead of writing a migration")
+				return func([]string) int {
+					return diffSchema(*schemaFile, *fromMigrations, *name, *dryRun, *check)
+				}
+			},
+		},
+		{
+			Name: "config", Args: "show", Summary: "Print the resolved configuration and where each value came from",
+			Complete: completeConfig,
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				formatFlag(fs)
+				return func(args []string) int {
+					if len(args) < 1 || args[0] != "show" {
+						fmt.Fprintln(os.Stderr, "Usage: duckdbm config show")
+						return exitUsage
+					}
+					return showConfig(activeConfig)
+				}
+			},
+		},
+		{
+			Name: "version", Summary: "Print version and build information", Local: true,
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				return func([]string) int {
+					printVersion(os.Stdout)
+					return exitOK
+				}
+			},
+		},
+		{
+			Name: "completion", Args: "bash|zsh|fish", Summary: "Print a shell completion script", Local: true,
+			Complete: completeShells,
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				return func(args []string) int {
+					if len(args) < 1 {
+						fmt.Fprintln(os.Stderr, "Please provide the shell: bash, zsh or fish.")
+						return exitUsage
+					}
+					return writeCompletion(os.Stdout, args[0])
+				}
+			},
+		},
+		{
+			Name: "help", Args: "[command]", Summary: "Show help for a command", Local: true,
+			Complete: completeCommands,
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				return func(args []string) int {
+					if len(args) == 0 {
+						printUsage(os.Stdout)
+						return exitOK
+					}
+					cmd, ok := findCommand(args[0])
+					if !ok {
+						fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
+						return exitUsage
+					}
+					printCommandUsage(os.Stdout, cmd)
+					return exitOK
+				}
+			},
+		},
+		{
+			// __complete prints completion candidates for the shell scripts.
+			Name: "__complete", Args: "<kind>", Local: true, Hidden: true,
+			Setup: func(fs *flag.FlagSet) func([]string) int {
+				return func(args []string) int {
+					if len(args) < 1 {
+						return exitUsage
+					}
+					loadConfigForCompletion()
+					for _, c := range completionCandidates(args[0]) {
+						fmt.Println(c)
+					}
+					return exitOK
+				}
+			},
+		},
+	}
+}
+
+// limitArg parses the optional [limit] left in args by list and history,
+// defaulting to 10. usage is the command's usage line for too many arguments.
+func limitArg(args []string, usage string) (int, bool) {
+	if len(args) > 1 {
+		fmt.Fprintf(os.Stderr, "Usage: duckdbm %s\n", usage)
+		return 0, false
+	}
+	if len(args) == 0 {
+		return 10, true
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		fmt.Fprintln(os.Stderr, "Please provide a valid positive number for the limit.")
+		return 0, false
+	}
+	return n, true
+}
+
+// findCommand returns the command called name.
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// addGlobalFlags registers the flags accepted both before the command and by
+// every command. Defaults are the current values, so a flag given before the
+// command is kept when the command's flags are parsed.
+func addGlobalFlags(fs *flag.FlagSet) {
+	fs.StringVar(&dbFile, "db", dbFile, "Database file (or set DATABASE)")
+	fs.StringVar(&configFile, "config", configFile, "Configuration file")
+	fs.StringVar(&envName, "env", envName, "Environment from the configuration file (or set DUCKDBM_ENV)")
+	fs.Func("env-file", "Load variables from this file after .env, .env.<env> and .env.local (repeatable)", func(v string) error {
+		envFiles = append(envFiles, v)
+		return nil
+	})
+	fs.DurationVar(&lockWait, "lock-wait", lockWait, "Keep retrying this long while another process holds the database lock, e.g. 2m (0 = fail at once)")
+	fs.DurationVar(&lockBackoff, "lock-backoff", lockBackoff, "Delay before the first lock retry; doubles on each retry up to 5s")
+	fs.DurationVar(&timeout, "timeout", timeout, "Cancel each migration, rollback or sync file after this long, e.g. 30m (0 = no limit)")
+	fs.StringVar(&logLevel, "log-level", logLevel, "Log level: debug, info, warn or error")
+	fs.StringVar(&logFormat, "log-format", logFormat, "Log format: text or json")
+	fs.StringVar(&logFile, "log-file", logFile, "Append logs to this file instead of stderr")
+}
+
+// parseInterspersed parses args with fs, allowing flags after positional
+// arguments, and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		// A "--" terminator makes everything after it positional.
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// run executes the command given in args (without the program name) and
+// returns its exit code.
+func run(args []string) int {
+	fs := flag.NewFlagSet("duckdbm", flag.ContinueOnError)
+	addGlobalFlags(fs)
+	fs.Usage = func() { printUsage(fs.Output()) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	cmd, ok := findCommand(fs.Arg(0))
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\nRun 'duckdbm help' for a list of commands.\n", fs.Arg(0))
+		return exitUsage
+	}
+
+	cfs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	runCmd := cmd.Setup(cfs)
+	addGlobalFlags(cfs)
+	cfs.Usage = func() { printCommandUsage(cfs.Output(), cmd) }
+	positional, err := parseInterspersed(cfs, fs.Args()[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	f, err := setupLogger(logLevel, logFormat, logFile, cmd.Name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if f != nil {
+		defer func() { _ = f.Close() }()
+	}
+
+	if !cmd.Local {
+		set := map[string]bool{}
+		visit := func(f *flag.Flag) { set[f.Name] = true }
+		fs.Visit(visit)
+		cfs.Visit(visit)
+		if code := loadEnvironment(set); code != exitOK {
+			return code
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// After the first signal, a second one kills the process as usual.
+		<-ctx.Done()
+		stop()
+	}()
+	rootCtx = ctx
+	defer func() { rootCtx = context.Background() }()
+
+	return runCmd(positional)
+}
+
+// loadEnvironment reads the configuration file, loads the .env files for the
+// selected environment and applies the resolved configuration. set holds the
+// names of the flags given on the command line.
+func loadEnvironment(set map[string]bool) int {
+	data, err := readConfigFile(configFile, set["config"])
+	if err != nil {
+		logger.Error("Invalid configuration", "error", err)
+		return exitUsage
+	}
+	env, envSource := data.selectEnvironment(envName)
+	if loadedEnvFiles, err = loadEnvFiles(env, envFiles); err != nil {
+		logger.Error("Failed to load env file", "error", err)
+		return exitUsage
+	}
+	if len(loadedEnvFiles) == 0 {
+		logger.Debug("No .env file found")
+	}
+
+	flagDB := ""
+	if set["db"] {
+		flagDB = dbFile
+	}
+	if activeConfig, err = data.resolve(env, envSource, flagDB); err != nil {
+		logger.Error("Invalid configuration", "error", err)
+		return exitUsage
+	}
+	if set["lock-wait"] {
+		if lockWait < 0 {
+			logger.Error("Invalid flag", "flag", "lock-wait", "error", "must not be negative")
+			return exitUsage
+		}
+		activeConfig.LockWait = lockWait
+		activeConfig.set("lock_wait", lockWait.String(), "flag --lock-wait")
+	}
+	if set["lock-backoff"] {
+		if lockBackoff <= 0 {
+			logger.Error("Invalid flag", "flag", "lock-backoff", "error", "must be positive")
+			return exitUsage
+		}
+		activeConfig.LockBackoff = lockBackoff
+		activeConfig.set("lock_backoff", lockBackoff.String(), "flag --lock-backoff")
+	}
+	activeConfig.set("env_files", strings.Join(loadedEnvFiles, ", "), "loaded")
+	activeConfig.apply()
+	return exitOK
+}
+
+// printUsage prints the list of commands.
+func printUsage(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "Usage: duckdbm [global flags] <command> [flags] [arguments]")
+	_, _ = fmt.Fprintln(w, "\nCommands:")
+	for _, cmd := range visibleCommands() {
+		_, _ = fmt.Fprintf(w, "  %-12s %s\n", cmd.Name, cmd.Summary)
+	}
+	_, _ = fmt.Fprintln(w, "\nGlobal flags:")
+	printGlobalFlags(w)
+	_, _ = fmt.Fprintln(w, "\nRun 'duckdbm help <command>' or 'duckdbm <command> --help' for a command's flags.")
+}
+
+// printCommandUsage prints a command's usage and flags.
+func printCommandUsage(w io.Writer, cmd command) {
+	usage := "duckdbm " + cmd.Name
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	cmd.Setup(fs)
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		usage += " [flags]"
+	}
+	if cmd.Args != "" {
+		usage += " " + cmd.Args
+	}
+	_, _ = fmt.Fprintf(w, "Usage: %s\n\n%s.\n", usage, cmd.Summary)
+	if hasFlags {
+		_, _ = fmt.Fprintln(w, "\nFlags:")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+	_, _ = fmt.Fprintln(w, "\nGlobal flags:")
+	printGlobalFlags(w)
+}
+
+func printGlobalFlags(w io.Writer) {
+	fs := flag.NewFlagSet("duckdbm", flag.ContinueOnError)
+	addGlobalFlags(fs)
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+// loadConfigForCompletion applies duckdbm.yaml, for migrations_dir, sync_dir
+// and sync_groups. Completion must stay silent, so a broken configuration is
+// ignored and no .env file is loaded.
+func loadConfigForCompletion() {
+	if cfg, err := loadConfig(configFile, false, envName, ""); err == nil {
+		cfg.apply()
+	}
+}
+
+// completionCandidates lists the values a positional argument of the given
+// kind can take.
+func completionCandidates(kind string) []string {
+	var candidates []string
+	switch kind {
+	case completeMigrations:
+		files, _ := listMigrationFiles(migrationsDir)
+		candidates = files
+	case completeSync:
+		candidates, _ = listSyncFiles()
+	case completeSyncGroups:
+		names, _ := listSyncFiles()
+		groups, _ := syncFileGroups(names)
+		candidates = sortedKeys(groups)
+	case completeCommands:
+		for _, cmd := range visibleCommands() {
+			candidates = append(candidates, cmd.Name)
+		}
+	case completeShells:
+		candidates = []string{"bash", "zsh", "fish"}
+	case completeConfig:
+		candidates = []string{"show"}
+	}
+	return candidates
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// restoreGlobalFlags puts the global flag and configuration values back
+// after a test runs a command line.
+func restoreGlobalFlags(t *testing.T) {
+	t.Helper()
+	restoreLogger(t)
+	prevLevel, prevFormat, prevFile := logLevel, logFormat, logFile
+	prevOutput := outputFormat
+	prevConfig, prevEnv, prevActive := configFile, envName, activeConfig
+	prevEnvFiles, prevLoaded := envFiles, loadedEnvFiles
+	prevSyncDir, prevKey, prevSettings, prevVars := syncDir, encryptionKey, duckdbSettings, macroVars
+	prevLockWait, prevLockBackoff := lockWait, lockBackoff
+	prevSchema, prevMigrations, prevSync := trackingSchema, migrationsTable, syncTable
+	prevGroups, prevWebhooks := syncGroups, webhookURLs
+	prevSchedules, prevJitter, prevMissed := syncSchedules, scheduleJitter, missedRuns
+	prevRetries, prevRetryOn := syncRetries, retryOn
+	t.Cleanup(func() {
+		logLevel, logFormat, logFile = prevLevel, prevFormat, prevFile
+		outputFormat = prevOutput
+		configFile, envName, activeConfig = prevConfig, prevEnv, prevActive
+		envFiles, loadedEnvFiles = prevEnvFiles, prevLoaded
+		syncDir, encryptionKey, duckdbSettings, macroVars = prevSyncDir, prevKey, prevSettings, prevVars
+		lockWait, lockBackoff = prevLockWait, prevLockBackoff
+		trackingSchema, migrationsTable, syncTable = prevSchema, prevMigrations, prevSync
+		syncGroups, webhookURLs = prevGroups, prevWebhooks
+		syncSchedules, scheduleJitter, missedRuns = prevSchedules, prevJitter, prevMissed
+		syncRetries, retryOn = prevRetries, prevRetryOn
+	})
+}
+
+func TestParseInterspersed(t *testing.T) {
+	prevDB := dbFile
+	t.Cleanup(func() { dbFile = prevDB })
+	cmd, _ := findCommand("validate")
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	cmd.Setup(fs)
+	addGlobalFlags(fs)
+	args, err := parseInterspersed(fs, []string{"003", "--apply", "--db", "x.db", "--", "--literal"})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if want := []string{"003", "--literal"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("positional args: want %v, got %v", want, args)
+	}
+	if fs.Lookup("apply").Value.String() != "true" {
+		t.Error("--apply after a positional argument was not parsed")
+	}
+	if dbFile != "x.db" {
+		t.Errorf("--db after a positional argument: got %q", dbFile)
+	}
+}
+
+func TestRun_GlobalFlagsBeforeOrAfterCommand(t *testing.T) {
+	restoreGlobalFlags(t)
+	dir := t.TempDir()
+	resetGlobals(t, "duckdb", dir)
+
+	for _, c := range []struct {
+		db   string
+		args []string
+	}{
+		{"test_cli_before.db", []string{"--db", "test_cli_before.db", "init"}},
+		{"test_cli_after.db", []string{"init", "--db", "test_cli_after.db"}},
+	} {
+		t.Cleanup(func() { os.Remove(c.db) })
+		dbFile = "duckdb"
+		if code := run(c.args); code != exitOK {
+			t.Fatalf("%v: want %d, got %d", c.args, exitOK, code)
+		}
+		if _, err := os.Stat(c.db); err != nil {
+			t.Errorf("%v: database not created: %v", c.args, err)
+		}
+	}
+}
+
+func TestRun_UsageExitCodes(t *testing.T) {
+	restoreGlobalFlags(t)
+	cases := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"help"}, exitOK},
+		{[]string{"help", "status"}, exitOK},
+		{[]string{"status", "--help"}, exitOK},
+		{[]string{"--help"}, exitOK},
+		{nil, exitUsage},
+		{[]string{"nosuch"}, exitUsage},
+		{[]string{"help", "nosuch"}, exitUsage},
+		{[]string{"status", "--nosuch"}, exitUsage},
+		{[]string{"rollback", "--to", "001_a.sql", "2"}, exitUsage},
+		{[]string{"completion", "powershell"}, exitUsage},
+		{[]string{"list", "nosuch"}, exitUsage},
+		{[]string{"list", "sync", "0"}, exitUsage},
+		{[]string{"list", "sync", "5", "6"}, exitUsage},
+		{[]string{"history", "many"}, exitUsage},
+	}
+	for _, c := range cases {
+		var code int
+		captureStdout(t, func() { code = run(c.args) })
+		if code != c.want {
+			t.Errorf("%v: want %d, got %d", c.args, c.want, code)
+		}
+	}
+}
+
+func TestRun_HelpListsCommandFlags(t *testing.T) {
+	restoreGlobalFlags(t)
+	out := captureStdout(t, func() { run([]string{"help", "rollback"}) })
+	for _, want := range []string{"Usage: duckdbm rollback [flags] [count]", "-to string", "-db string"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("help rollback should contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestRun_Version(t *testing.T) {
+	restoreGlobalFlags(t)
+	out := captureStdout(t, func() { run([]string{"version"}) })
+	if !strings.HasPrefix(out, "duckdbm "+version+"\n") {
+		t.Errorf("unexpected version output:\n%s", out)
+	}
+}
+
+func TestCompletionCandidates(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_cli_complete.db", dir)
+	for _, name := range []string{"002_b.sql", "001_a.sql", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := completionCandidates(completeMigrations), []string{"001_a.sql", "002_b.sql"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("migrations: want %v, got %v", want, got)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sync"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"users.sql": "-- GROUP nightly, hourly\n", "events.sql": "-- GROUP hourly\n"} {
+		if err := os.WriteFile(filepath.Join(dir, "sync", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := completionCandidates(completeSync), []string{"events", "users"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sync: want %v, got %v", want, got)
+	}
+	if got, want := completionCandidates(completeSyncGroups), []string{"hourly", "nightly"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sync groups: want %v, got %v", want, got)
+	}
+	for _, name := range completionCandidates(completeCommands) {
+		if strings.HasPrefix(name, "__") {
+			t.Errorf("hidden command %q offered for completion", name)
+		}
+	}
+}
+
+func TestRun_CompleteReadsConfig(t *testing.T) {
+	restoreGlobalFlags(t)
+	dir := t.TempDir()
+	resetGlobals(t, "test_cli_complete_config.db", t.TempDir())
+	writeSyncFiles(t, filepath.Join(dir, "db", "sync"), map[string]string{"users.sql": "-- MIGRATE\n"})
+	writeSyncFiles(t, filepath.Join(dir, "db"), map[string]string{"001_a.sql": "-- MIGRATE\n"})
+	configFile = filepath.Join(dir, defaultConfigFile)
+	if err := os.WriteFile(configFile, []byte("migrations_dir: db\nsync_groups:\n  nightly: [users]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for kind, want := range map[string]string{completeMigrations: "001_a.sql\n", completeSync: "users\n", completeSyncGroups: "nightly\n"} {
+		if got := captureStdout(t, func() { run([]string{"__complete", kind}) }); got != want {
+			t.Errorf("%s: want %q, got %q", kind, want, got)
+		}
+	}
+}
+
+func TestWriteCompletion(t *testing.T) {
+	cases := map[string][]string{
+		"bash": {
+			"complete -F _duckdbm duckdbm",
+			`sync) COMPREPLY=($(compgen -W "$(duckdbm __complete sync 2>/dev/null)"`,
+			`render) COMPREPLY=($(compgen -W "$(duckdbm __complete migrations 2>/dev/null)"`,
+			"rollback:-to|rollback:--to)",
+			"--allow-destructive",
+		},
+		"zsh": {
+			"#compdef duckdbm",
+			"'apply:Apply pending migrations'",
+			"rollback:-to|rollback:--to) compadd",
+			"compdef _duckdbm duckdbm",
+		},
+		"fish": {
+			"complete -c duckdbm -n __fish_use_subcommand -a sync",
+			"-n '__fish_seen_subcommand_from rollback' -l to -x -a '(duckdbm __complete migrations 2>/dev/null)'",
+			"-n '__fish_seen_subcommand_from sync' -a '(duckdbm __complete sync 2>/dev/null)'",
+		},
+	}
+	for shell, wants := range cases {
+		var b strings.Builder
+		if code := writeCompletion(&b, shell); code != exitOK {
+			t.Fatalf("%s: want %d, got %d", shell, exitOK, code)
+		}
+		for _, want := range wants {
+			if !strings.Contains(b.String(), want) {
+				t.Errorf("%s completion should contain %q", shell, want)
+			}
+		}
+		if strings.Contains(b.String(), "__complete:") || strings.Contains(b.String(), "-a __complete") {
+			t.Errorf("%s completion offers the hidden __complete command", shell)
+		}
+	}
+}
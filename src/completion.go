@@ -0,0 +1,316 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Values offered for the global flags in completion scripts. An empty list
+// completes file names.
+var globalFlagValues = map[string][]string{
+	"config":       nil,
+	"db":           nil,
+	"env":          {},
+	"env-file":     nil,
+	"log-level":    {"debug", "info", "warn", "error"},
+	"log-format":   {logFormatText, logFormatJSON},
+	"log-file":     nil,
+	"lock-wait":    {},
+	"lock-backoff": {},
+	"timeout":      {},
+}
+
+// writeCompletion writes the completion script for shell to w.
+func writeCompletion(w io.Writer, shell string) int {
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletion()
+	case "zsh":
+		script = zshCompletion()
+	case "fish":
+		script = fishCompletion()
+	default:
+		fmt.Fprintf(os.Stderr, "Unsupported shell %q (use bash, zsh or fish).\n", shell)
+		return exitUsage
+	}
+	_, _ = io.WriteString(w, script)
+	return exitOK
+}
+
+// visibleCommands returns the commands shown in help and completion.
+func visibleCommands() []command {
+	var cmds []command
+	for _, cmd := range commands {
+		if !cmd.Hidden {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+// commandFlags returns a command's own flags in name order.
+func commandFlags(cmd command) []*flag.Flag {
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	cmd.Setup(fs)
+	var flags []*flag.Flag
+	fs.VisitAll(func(f *flag.Flag) { flags = append(flags, f) })
+	return flags
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// globalFlagNames returns the global flag names in sorted order.
+func globalFlagNames() []string {
+	var names []string
+	for name := range globalFlagValues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// flagPatterns returns "-name|--name" for each name, for shell case patterns.
+func flagPatterns(names []string) string {
+	var patterns []string
+	for _, name := range names {
+		patterns = append(patterns, "-"+name, "--"+name)
+	}
+	return strings.Join(patterns, "|")
+}
+
+// dashed returns "--name" for each flag name.
+func dashed(names []string) string {
+	var out []string
+	for _, name := range names {
+		out = append(out, "--"+name)
+	}
+	return strings.Join(out, " ")
+}
+
+func completeCommand(kind string) string {
+	return fmt.Sprintf("duckdbm __complete %s 2>/dev/null", kind)
+}
+
+func bashCompletion() string {
+	var b strings.Builder
+	globals := globalFlagNames()
+
+	b.WriteString("# bash completion for duckdbm\n")
+	b.WriteString("# Load with: source <(duckdbm completion bash)\n\n")
+	b.WriteString("_duckdbm() {\n")
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	b.WriteString("    local cmd=\"\" i\n")
+	b.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	b.WriteString("        case \"${COMP_WORDS[i]}\" in\n")
+	fmt.Fprintf(&b, "            %s) ((i++)) ;;\n", flagPatterns(globals))
+	b.WriteString("            -*) ;;\n")
+	b.WriteString("            *) cmd=\"${COMP_WORDS[i]}\"; break ;;\n")
+	b.WriteString("        esac\n")
+	b.WriteString("    done\n\n")
+
+	b.WriteString("    case \"$prev\" in\n")
+	for _, name := range globals {
+		if values := globalFlagValues[name]; values != nil {
+			fmt.Fprintf(&b, "        %s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); return ;;\n", flagPatterns([]string{name}), strings.Join(values, " "))
+		} else {
+			fmt.Fprintf(&b, "        %s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", flagPatterns([]string{name}))
+		}
+	}
+	b.WriteString("    esac\n\n")
+
+	var names []string
+	for _, cmd := range visibleCommands() {
+		names = append(names, cmd.Name)
+	}
+	b.WriteString("    if [[ -z \"$cmd\" ]]; then\n")
+	fmt.Fprintf(&b, "        COMPREPLY=($(compgen -W \"%s %s\" -- \"$cur\"))\n", strings.Join(names, " "), dashed(globals))
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n\n")
+
+	b.WriteString("    case \"$cmd:$prev\" in\n")
+	for _, cmd := range visibleCommands() {
+		for _, f := range commandFlags(cmd) {
+			if kind, ok := cmd.FlagComplete[f.Name]; ok {
+				fmt.Fprintf(&b, "        %s:-%s|%s:--%s) COMPREPLY=($(compgen -W \"$(%s)\" -- \"$cur\")); return ;;\n",
+					cmd.Name, f.Name, cmd.Name, f.Name, completeCommand(kind))
+			}
+		}
+	}
+	b.WriteString("    esac\n\n")
+
+	b.WriteString("    if [[ \"$cur\" == -* ]]; then\n")
+	b.WriteString("        case \"$cmd\" in\n")
+	for _, cmd := range visibleCommands() {
+		var flags []string
+		for _, f := range commandFlags(cmd) {
+			flags = append(flags, f.Name)
+		}
+		if len(flags) > 0 {
+			fmt.Fprintf(&b, "            %s) COMPREPLY=($(compgen -W \"%s %s\" -- \"$cur\")) ;;\n", cmd.Name, dashed(flags), dashed(globals))
+		}
+	}
+	fmt.Fprintf(&b, "            *) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", dashed(globals))
+	b.WriteString("        esac\n")
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n\n")
+
+	b.WriteString("    case \"$cmd\" in\n")
+	for _, cmd := range visibleCommands() {
+		if cmd.Complete != "" {
+			fmt.Fprintf(&b, "        %s) COMPREPLY=($(compgen -W \"$(%s)\" -- \"$cur\")) ;;\n", cmd.Name, completeCommand(cmd.Complete))
+		}
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("}\n\n")
+	b.WriteString("complete -F _duckdbm duckdbm\n")
+	return b.String()
+}
+
+func zshCompletion() string {
+	var b strings.Builder
+	globals := globalFlagNames()
+
+	b.WriteString("#compdef duckdbm\n")
+	b.WriteString("# zsh completion for duckdbm\n")
+	b.WriteString("# Load with: source <(duckdbm completion zsh)\n\n")
+	b.WriteString("_duckdbm() {\n")
+	b.WriteString("    local -a commands\n")
+	b.WriteString("    commands=(\n")
+	for _, cmd := range visibleCommands() {
+		fmt.Fprintf(&b, "        %s\n", zshQuote(cmd.Name+":"+cmd.Summary))
+	}
+	b.WriteString("    )\n")
+	fmt.Fprintf(&b, "    local -a globals=(%s)\n", dashed(globals))
+	b.WriteString("    local cmd i\n")
+	b.WriteString("    for ((i = 2; i < CURRENT; i++)); do\n")
+	b.WriteString("        case ${words[i]} in\n")
+	fmt.Fprintf(&b, "            %s) ((i++)) ;;\n", flagPatterns(globals))
+	b.WriteString("            -*) ;;\n")
+	b.WriteString("            *) cmd=${words[i]}; break ;;\n")
+	b.WriteString("        esac\n")
+	b.WriteString("    done\n\n")
+
+	b.WriteString("    case ${words[CURRENT-1]} in\n")
+	for _, name := range globals {
+		if values := globalFlagValues[name]; values != nil {
+			fmt.Fprintf(&b, "        %s) compadd -- %s; return ;;\n", flagPatterns([]string{name}), strings.Join(values, " "))
+		} else {
+			fmt.Fprintf(&b, "        %s) _files; return ;;\n", flagPatterns([]string{name}))
+		}
+	}
+	b.WriteString("    esac\n\n")
+
+	b.WriteString("    if [[ -z $cmd ]]; then\n")
+	b.WriteString("        if [[ ${words[CURRENT]} == -* ]]; then\n")
+	b.WriteString("            compadd -- $globals\n")
+	b.WriteString("        else\n")
+	b.WriteString("            _describe 'command' commands\n")
+	b.WriteString("        fi\n")
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n\n")
+
+	b.WriteString("    case $cmd:${words[CURRENT-1]} in\n")
+	for _, cmd := range visibleCommands() {
+		for _, f := range commandFlags(cmd) {
+			if kind, ok := cmd.FlagComplete[f.Name]; ok {
+				fmt.Fprintf(&b, "        %s:-%s|%s:--%s) compadd -- ${(f)\"$(%s)\"}; return ;;\n",
+					cmd.Name, f.Name, cmd.Name, f.Name, completeCommand(kind))
+			}
+		}
+	}
+	b.WriteString("    esac\n\n")
+
+	b.WriteString("    if [[ ${words[CURRENT]} == -* ]]; then\n")
+	b.WriteString("        case $cmd in\n")
+	for _, cmd := range visibleCommands() {
+		var flags []string
+		for _, f := range commandFlags(cmd) {
+			flags = append(flags, f.Name)
+		}
+		if len(flags) > 0 {
+			fmt.Fprintf(&b, "            %s) compadd -- %s $globals ;;\n", cmd.Name, dashed(flags))
+		}
+	}
+	b.WriteString("            *) compadd -- $globals ;;\n")
+	b.WriteString("        esac\n")
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n\n")
+
+	b.WriteString("    case $cmd in\n")
+	for _, cmd := range visibleCommands() {
+		if cmd.Complete != "" {
+			fmt.Fprintf(&b, "        %s) compadd -- ${(f)\"$(%s)\"} ;;\n", cmd.Name, completeCommand(cmd.Complete))
+		}
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("}\n\n")
+	b.WriteString("if [[ \"$funcstack[1]\" == \"_duckdbm\" ]]; then\n")
+	b.WriteString("    _duckdbm \"$@\"\n")
+	b.WriteString("else\n")
+	b.WriteString("    compdef _duckdbm duckdbm\n")
+	b.WriteString("fi\n")
+	return b.String()
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+
+	b.WriteString("# fish completion for duckdbm\n")
+	b.WriteString("# Load with: duckdbm completion fish | source\n\n")
+	b.WriteString("complete -c duckdbm -f\n")
+	gfs := flag.NewFlagSet("duckdbm", flag.ContinueOnError)
+	addGlobalFlags(gfs)
+	gfs.VisitAll(func(f *flag.Flag) {
+		if values := globalFlagValues[f.Name]; values != nil {
+			fmt.Fprintf(&b, "complete -c duckdbm -l %s -x -a %s -d %s\n", f.Name, fishQuote(strings.Join(values, " ")), fishQuote(f.Usage))
+		} else {
+			fmt.Fprintf(&b, "complete -c duckdbm -l %s -r -F -d %s\n", f.Name, fishQuote(f.Usage))
+		}
+	})
+	b.WriteString("\n")
+
+	for _, cmd := range visibleCommands() {
+		fmt.Fprintf(&b, "complete -c duckdbm -n __fish_use_subcommand -a %s -d %s\n", cmd.Name, fishQuote(cmd.Summary))
+	}
+
+	for _, cmd := range visibleCommands() {
+		cond := fishQuote("__fish_seen_subcommand_from " + cmd.Name)
+		lines := []string{}
+		for _, f := range commandFlags(cmd) {
+			switch kind, ok := cmd.FlagComplete[f.Name]; {
+			case ok:
+				lines = append(lines, fmt.Sprintf("complete -c duckdbm -n %s -l %s -x -a %s -d %s", cond, f.Name, fishQuote("("+completeCommand(kind)+")"), fishQuote(f.Usage)))
+			case isBoolFlag(f):
+				lines = append(lines, fmt.Sprintf("complete -c duckdbm -n %s -l %s -d %s", cond, f.Name, fishQuote(f.Usage)))
+			default:
+				lines = append(lines, fmt.Sprintf("complete -c duckdbm -n %s -l %s -r -d %s", cond, f.Name, fishQuote(f.Usage)))
+			}
+		}
+		if cmd.Complete != "" {
+			lines = append(lines, fmt.Sprintf("complete -c duckdbm -n %s -a %s", cond, fishQuote("("+completeCommand(cmd.Complete)+")")))
+		}
+		if len(lines) > 0 {
+			b.WriteString("\n" + strings.Join(lines, "\n") + "\n")
+		}
+	}
+	return b.String()
+}
+
+// zshQuote single-quotes s for zsh.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote single-quotes s for fish.
+func fishQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
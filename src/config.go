@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is read from the working directory when --config is not
+// given. It is optional.
+const defaultConfigFile = "duckdbm.yaml"
+
+// Values set by the --config and --env global flags.
+var (
+	configFile = defaultConfigFile
+	envName    = ""
+)
+
+// Settings resolved from the configuration file and environment variables.
+var (
+	encryptionKey  = ""
+	webhookURLs    []string
+	duckdbSettings = map[string]string{}
+	macroVars      = map[string]string{}
+)
+
+// settingNameRe matches DuckDB setting names, which are interpolated into SET.
+var settingNameRe = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// configSection holds the values that can appear at the top level of
+// duckdbm.yaml and in each environment.
+type configSection struct {
+	Database        string              `yaml:"database"`
+	MigrationsDir   string              `yaml:"migrations_dir"`
+	SyncDir         string              `yaml:"sync_dir"`
+	TrackingSchema  string              `yaml:"tracking_schema"`
+	MigrationsTable string              `yaml:"migrations_table"`
+	SyncTable       string              `yaml:"sync_table"`
+	EncryptionKey   *keySource          `yaml:"encryption_key"`
+	LockWait        *time.Duration      `yaml:"lock_wait"`
+	LockBackoff     *time.Duration      `yaml:"lock_backoff"`
+	Webhooks        []string            `yaml:"webhooks"`
+	Settings        map[string]string   `yaml:"settings"`
+	Vars            map[string]string   `yaml:"vars"`
+	SyncGroups      map[string][]string `yaml:"sync_groups"`
+	SyncSchedules   map[string]string   `yaml:"sync_schedules"`
+	ScheduleJitter  *time.Duration      `yaml:"schedule_jitter"`
+	MissedRuns      string              `yaml:"missed_runs"`
+	SyncRetries     map[string]string   `yaml:"sync_retries"`
+	RetryOn         []string            `yaml:"retry_on"`
+}
+
+// keySource says where to read the encryption key from; the key itself is
+// never stored in the configuration file.
+type keySource struct {
+	Env  string `yaml:"env"`
+	File string `yaml:"file"`
+}
+
+type configFileData struct {
+	path          string // file read, or "" if there was none
+	configSection `yaml:",inline"`
+	DefaultEnv    string                   `yaml:"default_env"`
+	Environments  map[string]configSection `yaml:"environments"`
+}
+
+// configValue is one resolved setting and where it came from.
+type configValue struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// resolvedConfig is the outcome of merging defaults, duckdbm.yaml,
+// environment variables and flags.
+type resolvedConfig struct {
+	Path            string // configuration file read, or "" if there was none
+	Env             string
+	Database        string
+	MigrationsDir   string
+	SyncDir         string
+	TrackingSchema  string
+	MigrationsTable string
+	SyncTable       string
+	EncryptionKey   string
+	LockWait        time.Duration
+	LockBackoff     time.Duration
+	Webhooks        []string
+	Settings        map[string]string
+	Vars            map[string]string
+	SyncGroups      map[string][]string
+	SyncSchedules   map[string]string
+	ScheduleJitter  time.Duration
+	MissedRuns      string
+	SyncRetries     map[string]string
+	RetryOn         []string
+	Values          []configValue
+}
+
+// loadConfig reads path and resolves the configuration for env (or the
+// environment selected by DUCKDBM_ENV or default_env). flagDB is the -db
+// value if the flag was given.
+func loadConfig(path string, explicitPath bool, env, flagDB string) (*resolvedConfig, error) {
+	data, err := readConfigFile(path, explicitPath)
+	if err != nil {
+		return nil, err
+	}
+	env, envSource := data.selectEnvironment(env)
+	return data.resolve(env, envSource, flagDB)
+}
+
+// readConfigFile parses path. A missing file is only an error if the path was
+// given explicitly; otherwise the result is empty with path "".
+func readConfigFile(path string, explicitPath bool) (*configFileData, error) {
+	data := &configFileData{path: path}
+	raw, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		dec := yaml.NewDecoder(bytes.NewReader(raw))
+		dec.KnownFields(true)
+		if err = dec.Decode(data); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+	case os.IsNotExist(err) && !explicitPath:
+		data.path = ""
+	default:
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	return data, nil
+}
+
+// selectEnvironment returns the environment named by the --env flag,
+// DUCKDBM_ENV or default_env, in that order, and where the name came from.
+func (data *configFileData) selectEnvironment(flagEnv string) (string, string) {
+	switch {
+	case flagEnv != "":
+		return flagEnv, "flag --env"
+	case os.Getenv("DUCKDBM_ENV") != "":
+		return os.Getenv("DUCKDBM_ENV"), "env DUCKDBM_ENV"
+	case data.DefaultEnv != "":
+		return data.DefaultEnv, data.path + " (default_env)"
+	}
+	return "", ""
+}
+
+// set records where key's value came from, replacing an earlier source.
+func (cfg *resolvedConfig) set(key, value, source string) {
+	for i := range cfg.Values {
+		if cfg.Values[i].Key == key {
+			cfg.Values[i] = configValue{key, value, source}
+			return
+		}
+	}
+	cfg.Values = append(cfg.Values, configValue{key, value, source})
+}
+
+// resolve merges the file with environment variables and flags for env.
+// Precedence, highest first: flags, the selected environment, the top level
+// of the file, environment variables, defaults.
+func (data *configFileData) resolve(env, envSource, flagDB string) (*resolvedConfig, error) {
+	path := data.path
+	cfg := &resolvedConfig{Path: path, Env: env, Settings: map[string]string{}, Vars: map[string]string{}, SyncGroups: map[string][]string{}, SyncSchedules: map[string]string{}, SyncRetries: map[string]string{}}
+	set := cfg.set
+
+	type layer struct {
+		section *configSection
+		source  string
+	}
+	var layers []layer
+	if path != "" {
+		layers = append(layers, layer{&data.configSection, path})
+	}
+	if env != "" {
+		if path == "" {
+			return nil, fmt.Errorf("environment %q selected but no %s found", env, defaultConfigFile)
+		}
+		s, ok := data.Environments[env]
+		if !ok {
+			return nil, fmt.Errorf("environment %q is not defined in %s (defined: %s)", env, path, strings.Join(sortedKeys(data.Environments), ", "))
+		}
+		layers = append(layers, layer{&s, fmt.Sprintf("%s (%s)", path, env)})
+		set("env", env, envSource)
+	}
+
+	// Defaults and environment variables.
+	cfg.Database, cfg.MigrationsDir = "duckdb", "migrations"
+	set("database", cfg.Database, "default")
+	if v := os.Getenv("DATABASE"); v != "" {
+		cfg.Database = v
+		set("database", v, "env DATABASE")
+	}
+	set("migrations_dir", cfg.MigrationsDir, "default")
+	set("sync_dir", "", "default")
+	cfg.TrackingSchema, cfg.MigrationsTable, cfg.SyncTable = defaultTrackingSchema, defaultMigrationsTable, defaultSyncTable
+	set("tracking_schema", cfg.TrackingSchema, "default")
+	set("migrations_table", cfg.MigrationsTable, "default")
+	set("sync_table", cfg.SyncTable, "default")
+	keySrc, keyOrigin := &keySource{Env: "ENC_KEY"}, ""
+	cfg.LockWait, cfg.LockBackoff = 0, defaultLockBackoff
+	set("lock_wait", cfg.LockWait.String(), "default")
+	set("lock_backoff", cfg.LockBackoff.String(), "default")
+	cfg.ScheduleJitter, cfg.MissedRuns = 0, missedRunsSkip
+	set("schedule_jitter", cfg.ScheduleJitter.String(), "default")
+	set("missed_runs", cfg.MissedRuns, "default")
+	if v := os.Getenv("WEBHOOK_URL"); v != "" {
+		cfg.Webhooks = []string{v}
+		set("webhooks", v, "env WEBHOOK_URL")
+	}
+
+	// The top level of the file, then the selected environment.
+	dir := filepath.Dir(path)
+	for _, l := range layers {
+		s := l.section
+		if s.Database != "" {
+			cfg.Database = resolvePath(dir, s.Database)
+			set("database", cfg.Database, l.source)
+		}
+		if s.MigrationsDir != "" {
+			cfg.MigrationsDir = resolvePath(dir, s.MigrationsDir)
+			set("migrations_dir", cfg.MigrationsDir, l.source)
+		}
+		if s.SyncDir != "" {
+			cfg.SyncDir = resolvePath(dir, s.SyncDir)
+			set("sync_dir", cfg.SyncDir, l.source)
+		}
+		for _, ident := range []struct {
+			key, value string
+			dst        *string
+		}{
+			{"tracking_schema", s.TrackingSchema, &cfg.TrackingSchema},
+			{"migrations_table", s.MigrationsTable, &cfg.MigrationsTable},
+			{"sync_table", s.SyncTable, &cfg.SyncTable},
+		} {
+			if ident.value == "" {
+				continue
+			}
+			if !identRe.MatchString(ident.value) {
+				return nil, fmt.Errorf("%s: invalid %s %q", l.source, ident.key, ident.value)
+			}
+			*ident.dst = ident.value
+			set(ident.key, ident.value, l.source)
+		}
+		if s.EncryptionKey != nil {
+			if (s.EncryptionKey.Env == "") == (s.EncryptionKey.File == "") {
+				return nil, fmt.Errorf("%s: encryption_key needs exactly one of env or file", l.source)
+			}
+			keySrc, keyOrigin = s.EncryptionKey, l.source+": "
+			if keySrc.File != "" {
+				keySrc = &keySource{File: resolvePath(dir, keySrc.File)}
+			}
+		}
+		if s.LockWait != nil {
+			if *s.LockWait < 0 {
+				return nil, fmt.Errorf("%s: lock_wait must not be negative", l.source)
+			}
+			cfg.LockWait = *s.LockWait
+			set("lock_wait", cfg.LockWait.String(), l.source)
+		}
+		if s.LockBackoff != nil {
+			if *s.LockBackoff <= 0 {
+				return nil, fmt.Errorf("%s: lock_backoff must be positive", l.source)
+			}
+			cfg.LockBackoff = *s.LockBackoff
+			set("lock_backoff", cfg.LockBackoff.String(), l.source)
+		}
+		if len(s.Webhooks) > 0 {
+			cfg.Webhooks = s.Webhooks
+			set("webhooks", strings.Join(s.Webhooks, ", "), l.source)
+		}
+		for _, name := range sortedKeys(s.Settings) {
+			if !settingNameRe.MatchString(name) {
+				return nil, fmt.Errorf("%s: invalid DuckDB setting name %q", l.source, name)
+			}
+			cfg.Settings[name] = s.Settings[name]
+			set("settings."+name, s.Settings[name], l.source)
+		}
+		for _, name := range sortedKeys(s.Vars) {
+			cfg.Vars[name] = s.Vars[name]
+			set("vars."+name, s.Vars[name], l.source)
+		}
+		for _, name := range sortedKeys(s.SyncGroups) {
+			cfg.SyncGroups[name] = s.SyncGroups[name]
+			set("sync_groups."+name, strings.Join(s.SyncGroups[name], ", "), l.source)
+		}
+		for _, name := range sortedKeys(s.SyncSchedules) {
+			if _, err := parseScheduleSpec(s.SyncSchedules[name]); err != nil {
+				return nil, fmt.Errorf("%s: sync_schedules.%s: %v", l.source, name, err)
+			}
+			cfg.SyncSchedules[name] = s.SyncSchedules[name]
+			set("sync_schedules."+name, s.SyncSchedules[name], l.source)
+		}
+		if s.ScheduleJitter != nil {
+			if *s.ScheduleJitter < 0 {
+				return nil, fmt.Errorf("%s: schedule_jitter must not be negative", l.source)
+			}
+			cfg.ScheduleJitter = *s.ScheduleJitter
+			set("schedule_jitter", cfg.ScheduleJitter.String(), l.source)
+		}
+		if s.MissedRuns != "" {
+			if err := checkMissedRuns(s.MissedRuns); err != nil {
+				return nil, fmt.Errorf("%s: missed_runs: %v", l.source, err)
+			}
+			cfg.MissedRuns = s.MissedRuns
+			set("missed_runs", cfg.MissedRuns, l.source)
+		}
+		for _, name := range sortedKeys(s.SyncRetries) {
+			if _, err := parseRetrySpec(s.SyncRetries[name]); err != nil {
+				return nil, fmt.Errorf("%s: sync_retries.%s: %v", l.source, name, err)
+			}
+			cfg.SyncRetries[name] = s.SyncRetries[name]
+			set("sync_retries."+name, s.SyncRetries[name], l.source)
+		}
+		if len(s.RetryOn) > 0 {
+			if _, err := compileRetryPatterns(s.RetryOn); err != nil {
+				return nil, fmt.Errorf("%s: retry_on: %v", l.source, err)
+			}
+			cfg.RetryOn = s.RetryOn
+			set("retry_on", strings.Join(s.RetryOn, ", "), l.source)
+		}
+	}
+
+	if flagDB != "" {
+		cfg.Database = flagDB
+		set("database", flagDB, "flag -db")
+	}
+	if cfg.MigrationsTable == cfg.SyncTable {
+		return nil, fmt.Errorf("migrations_table and sync_table must differ")
+	}
+	if cfg.SyncDir == "" {
+		set("sync_dir", filepath.Join(cfg.MigrationsDir, "sync"), "default")
+	}
+
+	// config show reports where the key came from but never the key itself.
+	switch {
+	case keySrc.File != "":
+		key, err := os.ReadFile(keySrc.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key: %v", err)
+		}
+		cfg.EncryptionKey = strings.TrimSpace(string(key))
+		set("encryption_key", "********", keyOrigin+"file "+keySrc.File)
+	case os.Getenv(keySrc.Env) != "":
+		cfg.EncryptionKey = os.Getenv(keySrc.Env)
+		set("encryption_key", "********", keyOrigin+"env "+keySrc.Env)
+	default:
+		set("encryption_key", "", keyOrigin+"env "+keySrc.Env+" (unset)")
+	}
+	return cfg, nil
+}
+
+// apply copies the resolved configuration into the package globals used by
+// the commands.
+func (cfg *resolvedConfig) apply() {
+	dbFile = cfg.Database
+	migrationsDir = cfg.MigrationsDir
+	syncDir = cfg.SyncDir
+	trackingSchema, migrationsTable, syncTable = cfg.TrackingSchema, cfg.MigrationsTable, cfg.SyncTable
+	encryptionKey = cfg.EncryptionKey
+	lockWait, lockBackoff = cfg.LockWait, cfg.LockBackoff
+	webhookURLs = cfg.Webhooks
+	duckdbSettings = cfg.Settings
+	macroVars = cfg.Vars
+	syncGroups = cfg.SyncGroups
+	syncSchedules, scheduleJitter, missedRuns = cfg.SyncSchedules, cfg.ScheduleJitter, cfg.MissedRuns
+	syncRetries = cfg.SyncRetries
+	retryOn, _ = compileRetryPatterns(cfg.RetryOn)
+}
+
+// resolvePath makes a path from the configuration file relative to the
+// directory holding that file.
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) || dir == "." {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// showConfig prints the resolved configuration and where each value came
+// from.
+func showConfig(cfg *resolvedConfig) int {
+	title := "Configuration (no " + defaultConfigFile + "):"
+	if cfg.Path != "" {
+		title = "Configuration from " + cfg.Path + ":"
+	}
+	out := outputTable{
+		Title: title,
+		Columns: []outputColumn{
+			{Key: "key", Header: "Key"},
+			{Key: "value", Header: "Value"},
+			{Key: "source", Header: "Source"},
+		},
+	}
+	for _, v := range cfg.Values {
+		out.Rows = append(out.Rows, []any{v.Key, v.Value, v.Source})
+	}
+	if err := out.write(os.Stdout, outputFormat); err != nil {
+		logger.Error("Failed to write output", "error", err)
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+default_env: dev
+migrations_dir: db/migrations
+vars:
+  SCHEMA: main
+sync_groups:
+  nightly: [import_*]
+sync_schedules:
+  import_users: "*/15 * * * * missed=run"
+schedule_jitter: 30s
+environments:
+  dev:
+    database: dev.duckdb
+    settings:
+      threads: 2
+  prod:
+    database: /data/prod.duckdb
+    encryption_key:
+      file: prod.key
+    webhooks: [https://hooks.example.com/a]
+    lock_wait: 2m
+    vars:
+      SCHEMA: analytics
+`
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, defaultConfigFile)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func configSource(cfg *resolvedConfig, key string) string {
+	for _, v := range cfg.Values {
+		if v.Key == key {
+			return v.Source
+		}
+	}
+	return ""
+}
+
+func TestLoadConfig_DefaultEnv(t *testing.T) {
+	t.Setenv("DUCKDBM_ENV", "")
+	t.Setenv("DATABASE", "from_env.duckdb")
+	path := writeTestConfig(t, testConfig)
+	dir := filepath.Dir(path)
+
+	cfg, err := loadConfig(path, true, "", "")
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Env != "dev" {
+		t.Errorf("env: want dev, got %q", cfg.Env)
+	}
+	if want := filepath.Join(dir, "dev.duckdb"); cfg.Database != want {
+		t.Errorf("database: want %q, got %q", want, cfg.Database)
+	}
+	if got := configSource(cfg, "database"); got != path+" (dev)" {
+		t.Errorf("database source: got %q", got)
+	}
+	if want := filepath.Join(dir, "db/migrations"); cfg.MigrationsDir != want {
+		t.Errorf("migrations_dir: want %q, got %q", want, cfg.MigrationsDir)
+	}
+	if cfg.Settings["threads"] != "2" || cfg.Vars["SCHEMA"] != "main" {
+		t.Errorf("settings and vars: got %v, %v", cfg.Settings, cfg.Vars)
+	}
+	if got := cfg.SyncGroups["nightly"]; len(got) != 1 || got[0] != "import_*" || configSource(cfg, "sync_groups.nightly") != path {
+		t.Errorf("sync_groups: got %v from %q", got, configSource(cfg, "sync_groups.nightly"))
+	}
+	if cfg.SyncSchedules["import_users"] != "*/15 * * * * missed=run" || cfg.ScheduleJitter != 30*time.Second || cfg.MissedRuns != missedRunsSkip {
+		t.Errorf("schedules: got %v, jitter %s, missed %q", cfg.SyncSchedules, cfg.ScheduleJitter, cfg.MissedRuns)
+	}
+}
+
+func TestLoadConfig_SelectedEnvAndFlag(t *testing.T) {
+	path := writeTestConfig(t, testConfig)
+	if err := os.WriteFile(filepath.Join(filepath.Dir(path), "prod.key"), []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadConfig(path, true, "prod", "")
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Database != "/data/prod.duckdb" || cfg.EncryptionKey != "s3cret" || cfg.Vars["SCHEMA"] != "analytics" {
+		t.Errorf("unexpected prod config: %+v", cfg)
+	}
+	if cfg.LockWait != 2*time.Minute || cfg.LockBackoff != defaultLockBackoff {
+		t.Errorf("lock retry: got %s, %s", cfg.LockWait, cfg.LockBackoff)
+	}
+	if len(cfg.Webhooks) != 1 || cfg.Webhooks[0] != "https://hooks.example.com/a" {
+		t.Errorf("webhooks: got %v", cfg.Webhooks)
+	}
+	for _, v := range cfg.Values {
+		if strings.Contains(v.Value, "s3cret") {
+			t.Errorf("%s shows the encryption key", v.Key)
+		}
+	}
+
+	cfg, err = loadConfig(path, true, "prod", "override.duckdb")
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Database != "override.duckdb" || configSource(cfg, "database") != "flag -db" {
+		t.Errorf("-db should win over the environment: got %q from %q", cfg.Database, configSource(cfg, "database"))
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	t.Setenv("DUCKDBM_ENV", "")
+	path := writeTestConfig(t, testConfig)
+	cases := map[string]func() error{
+		"unknown environment": func() error { _, err := loadConfig(path, true, "qa", ""); return err },
+		"unknown field": func() error {
+			_, err := loadConfig(writeTestConfig(t, "databse: x.duckdb\n"), true, "", "")
+			return err
+		},
+		"bad setting name": func() error {
+			_, err := loadConfig(writeTestConfig(t, "settings:\n  \"threads; DROP\": 1\n"), true, "", "")
+			return err
+		},
+		"zero lock backoff": func() error {
+			_, err := loadConfig(writeTestConfig(t, "lock_backoff: 0s\n"), true, "", "")
+			return err
+		},
+		"bad lock wait": func() error {
+			_, err := loadConfig(writeTestConfig(t, "lock_wait: soon\n"), true, "", "")
+			return err
+		},
+		"bad tracking schema": func() error {
+			_, err := loadConfig(writeTestConfig(t, "tracking_schema: \"dm; DROP\"\n"), true, "", "")
+			return err
+		},
+		"same tracking tables": func() error {
+			_, err := loadConfig(writeTestConfig(t, "migrations_table: runs\nsync_table: runs\n"), true, "", "")
+			return err
+		},
+		"bad sync schedule": func() error {
+			_, err := loadConfig(writeTestConfig(t, "sync_schedules:\n  import_users: \"61 * * * *\"\n"), true, "", "")
+			return err
+		},
+		"bad missed runs": func() error {
+			_, err := loadConfig(writeTestConfig(t, "missed_runs: later\n"), true, "", "")
+			return err
+		},
+		"bad sync retry": func() error {
+			_, err := loadConfig(writeTestConfig(t, "sync_retries:\n  import_users: \"0 backoff=1s\"\n"), true, "", "")
+			return err
+		},
+		"bad retry pattern": func() error {
+			_, err := loadConfig(writeTestConfig(t, "retry_on: [\"(timeout\"]\n"), true, "", "")
+			return err
+		},
+		"missing explicit file": func() error {
+			_, err := loadConfig(filepath.Join(t.TempDir(), "nope.yaml"), true, "", "")
+			return err
+		},
+		"env without a file": func() error {
+			_, err := loadConfig(filepath.Join(t.TempDir(), defaultConfigFile), false, "prod", "")
+			return err
+		},
+	}
+	for name, load := range cases {
+		if load() == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadConfig_NoFileUsesEnvironment(t *testing.T) {
+	t.Setenv("DUCKDBM_ENV", "")
+	t.Setenv("DATABASE", "from_env.duckdb")
+	t.Setenv("ENC_KEY", "k")
+
+	cfg, err := loadConfig(filepath.Join(t.TempDir(), defaultConfigFile), false, "", "")
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Path != "" || cfg.Database != "from_env.duckdb" || cfg.MigrationsDir != "migrations" || cfg.EncryptionKey != "k" {
+		t.Errorf("unexpected config without a file: %+v", cfg)
+	}
+}
+
+func TestShowConfig_JSON(t *testing.T) {
+	t.Setenv("DUCKDBM_ENV", "")
+	setOutputFormat(t, formatJSON)
+	path := writeTestConfig(t, testConfig)
+	cfg, err := loadConfig(path, true, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, func() { showConfig(cfg) })
+	var rows []map[string]any
+	if err = json.Unmarshal([]byte(out), &rows); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out)
+	}
+	found := false
+	for _, r := range rows {
+		if r["key"] == "settings.threads" {
+			found = r["value"] == "2" && r["source"] == path+" (dev)"
+		}
+	}
+	if !found {
+		t.Errorf("settings.threads missing or wrong in %s", out)
+	}
+}
+
+func TestProcessMacros_ConfigVarsWin(t *testing.T) {
+	prev := macroVars
+	t.Cleanup(func() { macroVars = prev })
+	macroVars = map[string]string{"SCHEMA": "analytics"}
+	t.Setenv("SCHEMA", "main")
+	t.Setenv("TABLE", "users")
+
+	got, _ := processMacros("SELECT * FROM {{SCHEMA}}.{{TABLE}}")
+	if got != "SELECT * FROM analytics.users" {
+		t.Errorf("got %q", got)
+	}
+}
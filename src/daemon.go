@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Policies for scheduled runs that did not happen on time, because the
+// daemon was not running or the previous run was still going.
+const (
+	missedRunsSkip = "skip" // wait for the next scheduled time
+	missedRunsRun  = "run"  // run once as soon as possible
+)
+
+// scheduleDirectiveRe matches -- SCHEDULE */15 * * * *, optionally followed
+// by jitter=30s and missed=skip|run.
+var scheduleDirectiveRe = regexp.MustCompile(`(?im)^[ \t]*--[ \t]*SCHEDULE[ \t]+(.+?)[ \t]*$`)
+
+// Daemon settings from duckdbm.yaml. sync_schedules maps a sync file name to
+// a schedule and overrides its -- SCHEDULE header.
+var (
+	syncSchedules  = map[string]string{}
+	scheduleJitter time.Duration
+	missedRuns     = missedRunsSkip
+)
+
+// scheduleSpec is a parsed schedule: a cron expression and the options that
+// override schedule_jitter and missed_runs.
+type scheduleSpec struct {
+	Expr   string
+	Cron   *cronSchedule
+	Jitter *time.Duration
+	Missed string
+}
+
+func checkMissedRuns(v string) error {
+	if v != missedRunsSkip && v != missedRunsRun {
+		return fmt.Errorf("unknown policy %q (use %s or %s)", v, missedRunsSkip, missedRunsRun)
+	}
+	return nil
+}
+
+// parseScheduleSpec parses "*/15 * * * * jitter=30s missed=run" or
+// "@daily".
+func parseScheduleSpec(spec string) (scheduleSpec, error) {
+	fields := strings.Fields(spec)
+	n := 5
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		n = 1
+	}
+	if len(fields) < n {
+		return scheduleSpec{}, fmt.Errorf("invalid schedule %q: want 5 cron fields or a macro such as @daily", spec)
+	}
+	s := scheduleSpec{Expr: strings.Join(fields[:n], " ")}
+	var err error
+	if s.Cron, err = parseCron(s.Expr); err != nil {
+		return s, err
+	}
+	for _, opt := range fields[n:] {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "jitter":
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return s, fmt.Errorf("invalid jitter %q", value)
+			}
+			s.Jitter = &d
+		case "missed":
+			if err = checkMissedRuns(value); err != nil {
+				return s, err
+			}
+			s.Missed = value
+		default:
+			return s, fmt.Errorf("unknown schedule option %q (use jitter=<duration> or missed=skip|run)", opt)
+		}
+	}
+	return s, nil
+}
+
+// syncJob is a sync file the daemon runs on a schedule.
+type syncJob struct {
+	Name   string
+	Path   string
+	Source string // where the schedule came from
+	Expr   string
+	Cron   *cronSchedule
+	Jitter time.Duration
+	Missed string
+
+	next time.Time // scheduled time of the next run
+	due  time.Time // next plus jitter
+}
+
+// loadSyncJobs returns the scheduled files in the sync directory, sorted by
+// name.
+func loadSyncJobs() ([]*syncJob, error) {
+	names, err := listSyncFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync directory: %v", err)
+	}
+	exists := map[string]bool{}
+	var jobs []*syncJob
+	for _, name := range names {
+		exists[name] = true
+		path := filepath.Join(syncDirectory(), name+".sql")
+		spec, source := syncSchedules[name], "sync_schedules."+name
+		if spec == "" {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			m := scheduleDirectiveRe.FindAllStringSubmatch(string(content), -1)
+			if len(m) == 0 {
+				continue
+			}
+			if len(m) > 1 {
+				return nil, fmt.Errorf("%s: more than one SCHEDULE directive", path)
+			}
+			spec, source = m[0][1], path
+		}
+		s, err := parseScheduleSpec(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		job := &syncJob{Name: name, Path: path, Source: source, Expr: s.Expr, Cron: s.Cron, Jitter: scheduleJitter, Missed: missedRuns}
+		if s.Jitter != nil {
+			job.Jitter = *s.Jitter
+		}
+		if s.Missed != "" {
+			job.Missed = s.Missed
+		}
+		jobs = append(jobs, job)
+	}
+	for _, name := range sortedKeys(syncSchedules) {
+		if !exists[name] {
+			return nil, fmt.Errorf("sync_schedules.%s: no sync file %s.sql in %s", name, name, syncDirectory())
+		}
+	}
+	return jobs, nil
+}
+
+// plan schedules the job's next run at the first scheduled time after t.
+func (j *syncJob) plan(t time.Time) {
+	j.setNext(j.Cron.next(t))
+}
+
+func (j *syncJob) setNext(t time.Time) {
+	j.next, j.due = t, t
+	if j.Jitter > 0 && !t.IsZero() {
+		j.due = t.Add(rand.N(j.Jitter))
+	}
+}
+
+// start schedules the first run of the job when the daemon starts. lastRun is
+// when the file last ran, or zero. A scheduled time that passed since then
+// was missed: with the run policy the job runs right away, otherwise at its
+// next scheduled time.
+func (j *syncJob) start(now, lastRun time.Time) {
+	j.plan(now)
+	if lastRun.IsZero() {
+		return
+	}
+	// Runs are recorded in UTC; schedules are in the daemon's time zone.
+	lastRun = lastRun.In(now.Location())
+	if missed := j.Cron.next(lastRun); !missed.IsZero() && !missed.After(now) {
+		if j.Missed == missedRunsRun {
+			logger.Info("Running a missed scheduled sync", "migration", j.Name, "last_run", lastRun, "missed", missed)
+			j.setNext(now)
+			return
+		}
+		logger.Info("Skipping a missed scheduled sync", "migration", j.Name, "last_run", lastRun, "missed", missed, "next", j.next)
+	}
+}
+
+// reschedule plans the run after the one that just finished, or could not
+// start, at now. Scheduled times that passed meanwhile are missed: they are
+// never run one by one, but the run policy runs the job once more right away.
+func (j *syncJob) reschedule(now time.Time) {
+	next, missed := j.Cron.next(j.next), 0
+	for !next.IsZero() && !next.After(now) {
+		missed++
+		next = j.Cron.next(next)
+	}
+	if missed == 0 {
+		j.setNext(next)
+		return
+	}
+	if j.Missed == missedRunsRun {
+		logger.Warn("Scheduled syncs were missed while the database was busy; running once now", "migration", j.Name, "missed", missed)
+		j.setNext(now)
+		return
+	}
+	logger.Warn("Scheduled syncs were missed while the database was busy; skipping them", "migration", j.Name, "missed", missed, "next", next)
+	j.setNext(next)
+}
+
+// lastSyncRuns returns when each sync file last started.
+func lastSyncRuns(db *sql.DB) (map[string]time.Time, error) {
+	rows, err := db.QueryContext(rootCtx, "SELECT filename, max(applied_at) FROM "+syncTableRef()+" GROUP BY filename")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	last := map[string]time.Time{}
+	for rows.Next() {
+		var name string
+		var t time.Time
+		if err = rows.Scan(&name, &t); err != nil {
+			return nil, err
+		}
+		last[name] = t
+	}
+	return last, rows.Err()
+}
+
+// runDaemon implements the daemon command. SIGINT or SIGTERM stops it from
+// starting new runs and waits for the running one; a second signal, or
+// shutdownTimeout, cancels that run.
+func runDaemon(list bool, shutdownTimeout time.Duration) int {
+	jobs, err := loadSyncJobs()
+	if err != nil {
+		logger.Error("Invalid schedule", "error", err)
+		return exitUsage
+	}
+	if len(jobs) == 0 {
+		logger.Error("No scheduled sync files; add a -- SCHEDULE header or sync_schedules", "sync_dir", syncDirectory())
+		return exitUsage
+	}
+	if list {
+		return listSyncJobs(jobs, time.Now())
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		logger.Error("Failed to connect to the database", "error", err)
+		return connectionExitCode(err)
+	}
+	if ok, err := hasTable(db, trackingSchema, syncTable); err != nil {
+		_ = db.Close()
+		logger.Error("Failed to check the sync table", "error", err)
+		return exitFailure
+	} else if !ok {
+		_ = db.Close()
+		logger.Error("Table not initialized. Run 'init' first.", "table", syncTable)
+		return exitFailure
+	}
+	last, err := lastSyncRuns(db)
+	_ = db.Close()
+	if err != nil {
+		logger.Error("Failed to read the sync history", "error", err)
+		return exitFailure
+	}
+	now := time.Now()
+	for _, j := range jobs {
+		j.start(now, last[j.Name])
+	}
+
+	// rootCtx is cancelled by the first signal, which must not interrupt the
+	// running sync, so the jobs get a context of their own.
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	stop, stopDaemon := context.WithCancel(context.Background())
+	defer stopDaemon()
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	go func() {
+		select {
+		case <-sigs:
+		case <-stop.Done():
+			return
+		}
+		logger.Info("Shutting down after the running sync")
+		stopDaemon()
+		var timeoutC <-chan time.Time
+		if shutdownTimeout > 0 {
+			timeoutC = time.After(shutdownTimeout)
+		}
+		select {
+		case <-sigs:
+		case <-timeoutC:
+		}
+		logger.Warn("Cancelling the running sync")
+		cancelJobs()
+	}()
+	prevCtx, prevStop, prevSpinner := rootCtx, stopCtx, syncSpinner
+	rootCtx, stopCtx, syncSpinner = jobsCtx, stop, false
+	defer func() { rootCtx, stopCtx, syncSpinner = prevCtx, prevStop, prevSpinner }()
+
+	logger.Info("Daemon started", "jobs", len(jobs))
+	runScheduler(stop, jobs)
+	logger.Info("Daemon stopped")
+	if jobsCtx.Err() != nil {
+		return exitCancelled
+	}
+	return exitOK
+}
+
+// runScheduler runs jobs when they are due until stop is cancelled.
+func runScheduler(stop context.Context, jobs []*syncJob) {
+	for {
+		jobs = pendingJobs(jobs)
+		if len(jobs) == 0 {
+			logger.Warn("No scheduled runs left")
+			return
+		}
+		first := jobs[0]
+		if wait := time.Until(first.due); wait > 0 {
+			logger.Debug("Waiting for the next scheduled sync", "migration", first.Name, "at", first.due)
+			timer := time.NewTimer(wait)
+			select {
+			case <-stop.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+		if stop.Err() != nil {
+			return
+		}
+		runDueJobs(stop, jobs)
+	}
+}
+
+// pendingJobs drops jobs whose schedule has no next time and sorts the rest
+// by when they are due.
+func pendingJobs(jobs []*syncJob) []*syncJob {
+	pending := jobs[:0]
+	for _, j := range jobs {
+		if !j.next.IsZero() {
+			pending = append(pending, j)
+		}
+	}
+	sort.SliceStable(pending, func(a, b int) bool { return pending[a].due.Before(pending[b].due) })
+	return pending
+}
+
+// runDueJobs runs the due jobs one at a time on a single writer connection,
+// which it closes when none is due, so that other processes can open the
+// database between runs. A job is never run while its previous run is going.
+func runDueJobs(stop context.Context, jobs []*syncJob) {
+	db, err := connectDB()
+	if err != nil {
+		logger.Error("Failed to connect to the database", "error", err)
+		for _, j := range jobs {
+			if !j.due.After(time.Now()) {
+				j.reschedule(time.Now())
+			}
+		}
+		return
+	}
+	defer func(db *sql.DB) { _ = db.Close() }(db)
+
+	for stop.Err() == nil {
+		jobs = pendingJobs(jobs)
+		if len(jobs) == 0 || jobs[0].due.After(time.Now()) {
+			return
+		}
+		j := jobs[0]
+		logger.Info("Running scheduled sync", "migration", j.Name, "scheduled", j.next)
+		durationMs, _, err := runSyncOn(db, j.Name, j.Path)
+		if err != nil {
+			logger.Error("Error syncing", "migration", j.Name, "duration_ms", durationMs, "error", err)
+		} else {
+			logger.Info("Successfully synced", "migration", j.Name, "duration_ms", durationMs)
+		}
+		j.reschedule(time.Now())
+		if !j.next.IsZero() {
+			logger.Debug("Next scheduled sync", "migration", j.Name, "at", j.due)
+		}
+	}
+}
+
+// listSyncJobs prints the scheduled sync files and when they run next.
+func listSyncJobs(jobs []*syncJob, now time.Time) int {
+	out := outputTable{
+		Title: "Scheduled syncs:",
+		Columns: []outputColumn{
+			{Key: "name", Header: "Name"},
+			{Key: "schedule", Header: "Schedule"},
+			{Key: "jitter", Header: "Jitter"},
+			{Key: "missed", Header: "Missed Runs"},
+			{Key: "next_run", Header: "Next Run"},
+			{Key: "source", Header: "Source"},
+		},
+	}
+	for _, j := range jobs {
+		var next any
+		if t := j.Cron.next(now); !t.IsZero() {
+			next = t
+		}
+		out.Rows = append(out.Rows, []any{j.Name, j.Expr, j.Jitter.String(), j.Missed, next, j.Source})
+	}
+	if err := out.write(os.Stdout, outputFormat); err != nil {
+		logger.Error("Failed to write output", "error", err)
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleSpec(t *testing.T) {
+	s, err := parseScheduleSpec("*/15 * * * *  jitter=30s missed=run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Expr != "*/15 * * * *" || s.Jitter == nil || *s.Jitter != 30*time.Second || s.Missed != missedRunsRun {
+		t.Errorf("got %+v", s)
+	}
+	if s, err = parseScheduleSpec("@daily"); err != nil || s.Expr != "@daily" || s.Jitter != nil || s.Missed != "" {
+		t.Errorf("@daily: got %+v, %v", s, err)
+	}
+	for _, bad := range []string{"", "* * *", "* * * * * jitter=soon", "* * * * * missed=later", "* * * * * retry=3", "@daily jitter=-1s"} {
+		if _, err := parseScheduleSpec(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestLoadSyncJobs(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "test_jobs.db"), dir)
+	prevSchedules, prevJitter, prevMissed := syncSchedules, scheduleJitter, missedRuns
+	t.Cleanup(func() { syncSchedules, scheduleJitter, missedRuns = prevSchedules, prevJitter, prevMissed })
+	syncSchedules = map[string]string{"import_events": "@hourly missed=run"}
+	scheduleJitter, missedRuns = time.Minute, missedRunsSkip
+	writeSyncFiles(t, filepath.Join(dir, "sync"), map[string]string{
+		"import_users.sql":  "-- SCHEDULE */15 * * * * jitter=10s\n-- MIGRATE\nSELECT 1;",
+		"import_events.sql": "-- SCHEDULE 0 2 * * *\n-- MIGRATE\nSELECT 1;",
+		"adhoc.sql":         "-- MIGRATE\nSELECT 1;",
+	})
+
+	jobs, err := loadSyncJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("want 2 jobs, got %d", len(jobs))
+	}
+	events, users := jobs[0], jobs[1]
+	if events.Name != "import_events" || events.Expr != "@hourly" || events.Source != "sync_schedules.import_events" ||
+		events.Jitter != time.Minute || events.Missed != missedRunsRun {
+		t.Errorf("import_events: got %+v", events)
+	}
+	if users.Name != "import_users" || users.Expr != "*/15 * * * *" || users.Jitter != 10*time.Second || users.Missed != missedRunsSkip {
+		t.Errorf("import_users: got %+v", users)
+	}
+
+	syncSchedules = map[string]string{"missing": "@daily"}
+	if _, err := loadSyncJobs(); err == nil {
+		t.Error("schedule for a missing file: expected an error")
+	}
+	syncSchedules = nil
+	writeSyncFiles(t, filepath.Join(dir, "sync"), map[string]string{"adhoc.sql": "-- SCHEDULE 61 * * * *\n-- MIGRATE\nSELECT 1;"})
+	if _, err := loadSyncJobs(); err == nil || !strings.Contains(err.Error(), "adhoc.sql") {
+		t.Errorf("invalid header: got %v", err)
+	}
+}
+
+func TestSyncJobScheduling(t *testing.T) {
+	every15, _ := parseCron("*/15 * * * *")
+	at := func(h, m int) time.Time { return time.Date(2026, 3, 14, h, m, 0, 0, time.UTC) }
+
+	t.Run("start without history", func(t *testing.T) {
+		j := &syncJob{Cron: every15, Missed: missedRunsRun}
+		j.start(at(10, 7), time.Time{})
+		if !j.next.Equal(at(10, 15)) {
+			t.Errorf("next = %s", j.next)
+		}
+	})
+	t.Run("start after a missed run", func(t *testing.T) {
+		j := &syncJob{Cron: every15, Missed: missedRunsRun}
+		j.start(at(10, 7), at(9, 30))
+		if !j.next.Equal(at(10, 7)) {
+			t.Errorf("run policy: next = %s, want now", j.next)
+		}
+		j = &syncJob{Cron: every15, Missed: missedRunsSkip}
+		j.start(at(10, 7), at(9, 30))
+		if !j.next.Equal(at(10, 15)) {
+			t.Errorf("skip policy: next = %s", j.next)
+		}
+	})
+	t.Run("reschedule after a long run", func(t *testing.T) {
+		j := &syncJob{Cron: every15, Missed: missedRunsSkip}
+		j.setNext(at(10, 0))
+		j.reschedule(at(10, 40))
+		if !j.next.Equal(at(10, 45)) {
+			t.Errorf("skip policy: next = %s", j.next)
+		}
+		j = &syncJob{Cron: every15, Missed: missedRunsRun}
+		j.setNext(at(10, 0))
+		j.reschedule(at(10, 40))
+		if !j.next.Equal(at(10, 40)) {
+			t.Errorf("run policy: next = %s, want now", j.next)
+		}
+		j.reschedule(at(10, 41))
+		if !j.next.Equal(at(10, 45)) {
+			t.Errorf("after catching up: next = %s", j.next)
+		}
+	})
+	t.Run("jitter", func(t *testing.T) {
+		j := &syncJob{Cron: every15, Jitter: time.Minute}
+		j.plan(at(10, 7))
+		if d := j.due.Sub(j.next); d < 0 || d >= time.Minute {
+			t.Errorf("jitter = %s", d)
+		}
+	})
+}
+
+func TestRunDueJobs(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "test_daemon.db"), dir)
+	setOutputFormat(t, formatJSON)
+	initialize()
+	writeSyncFiles(t, filepath.Join(dir, "sync"), map[string]string{
+		"ok.sql":     "-- MIGRATE\nCREATE OR REPLACE TABLE t AS SELECT 1 AS x;",
+		"broken.sql": "-- MIGRATE\nSELECT * FROM missing_table;",
+		"later.sql":  "-- MIGRATE\nSELECT 1;",
+	})
+	every, _ := parseCron("* * * * *")
+	now := time.Now()
+	var jobs []*syncJob
+	for _, name := range []string{"ok", "broken", "later"} {
+		j := &syncJob{Name: name, Path: filepath.Join(dir, "sync", name+".sql"), Cron: every, Missed: missedRunsSkip}
+		j.setNext(now.Add(-time.Second))
+		jobs = append(jobs, j)
+	}
+	jobs[2].setNext(now.Add(time.Hour))
+
+	runDueJobs(context.Background(), jobs)
+
+	for _, j := range jobs {
+		if !j.next.After(now) {
+			t.Errorf("%s: not rescheduled, next = %s", j.Name, j.next)
+		}
+	}
+	out := captureStdout(t, func() { showSyncHistory(nil) })
+	var trends []map[string]any
+	if err := json.Unmarshal([]byte(out), &trends); err != nil {
+		t.Fatalf("history output %q: %v", out, err)
+	}
+	got := map[string]string{}
+	for _, r := range trends {
+		got[r["filename"].(string)] = r["last_status"].(string)
+	}
+	if len(got) != 2 || got["ok"] != statusSuccess || got["broken"] != statusFailed {
+		t.Errorf("runs: got %v", got)
+	}
+}
+
+func TestRunScheduler_StopsWhileWaiting(t *testing.T) {
+	hourly, _ := parseCron("@hourly")
+	j := &syncJob{Name: "later", Cron: hourly}
+	j.setNext(time.Now().Add(time.Hour))
+	stop, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runScheduler(stop, []*syncJob{j})
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler did not stop")
+	}
+}
@@ -1,70 +1,267 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	_ "github.com/duckdb/duckdb-go/v2"
 )
 
-const (
-	migrationsTableSQL = `
-CREATE SEQUENCE IF NOT EXISTS attached_db.seq_id START 1;
-CREATE TABLE IF NOT EXISTS attached_db.migrations (
-    id INTEGER PRIMARY KEY DEFAULT nextval('attached_db.seq_id'),
+// migrationsTableSQL creates the migrations table in the tracking schema in
+// its latest layout. Older tables are brought up to date by
+// toolSchemaUpgrades.
+func migrationsTableSQL() string {
+	return fmt.Sprintf(`
+CREATE SCHEMA IF NOT EXISTS attached_db.%[1]s;
+CREATE SEQUENCE IF NOT EXISTS %[2]s START 1;
+CREATE TABLE IF NOT EXISTS %[3]s (
+    id INTEGER PRIMARY KEY DEFAULT nextval(%[4]s),
     filename TEXT NOT NULL UNIQUE,
     applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    duration_ms INTEGER
+    duration_ms INTEGER,
+    settings VARCHAR
 );
-`
-	syncTableSQL = `
-CREATE SEQUENCE IF NOT EXISTS attached_db.seq_sync_id START 1;
-CREATE TABLE IF NOT EXISTS attached_db.sync (
-    id INTEGER PRIMARY KEY DEFAULT nextval('attached_db.seq_sync_id'),
+`, quoteIdent(trackingSchema), trackingName(migrationsSequence), migrationsTableRef(), quoteLiteral(trackingName(migrationsSequence)))
+}
+
+// syncTableSQL creates the sync table in the tracking schema in its latest
+// layout.
+func syncTableSQL() string {
+	return fmt.Sprintf(`
+CREATE SCHEMA IF NOT EXISTS attached_db.%[1]s;
+CREATE SEQUENCE IF NOT EXISTS %[2]s START 1;
+CREATE TABLE IF NOT EXISTS %[3]s (
+    id INTEGER PRIMARY KEY DEFAULT nextval(%[4]s),
     filename TEXT NOT NULL,
     applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    duration_ms INTEGER
+    duration_ms INTEGER,
+    settings VARCHAR,
+    status VARCHAR,
+    run_id VARCHAR,
+    finished_at TIMESTAMP,
+    error VARCHAR,
+    rows_affected VARCHAR,
+    host VARCHAR,
+    attempt INTEGER
 );
-`
-)
+`, quoteIdent(trackingSchema), trackingName(syncSequence), syncTableRef(), quoteLiteral(trackingName(syncSequence)))
+}
+
+// metaTableSQL creates the table recording which toolSchemaUpgrades have run.
+func metaTableSQL() string {
+	return fmt.Sprintf(`
+CREATE SCHEMA IF NOT EXISTS attached_db.%[1]s;
+CREATE TABLE IF NOT EXISTS %[2]s (
+    version INTEGER PRIMARY KEY,
+    description VARCHAR,
+    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    tool_version VARCHAR
+);
+`, quoteIdent(trackingSchema), trackingName(metaTable))
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
 
 var migrationsDir = "migrations"
-var dbFile string
+var dbFile = "duckdb"
+
+// syncDir overrides where sync files live; by default it is the sync
+// subdirectory of migrationsDir.
+var syncDir = ""
+
+// syncDirectory returns the directory holding sync files.
+func syncDirectory() string {
+	if syncDir != "" {
+		return syncDir
+	}
+	return filepath.Join(migrationsDir, "sync")
+}
 
+// connectDB attaches the database read-write, creating the file if needed.
 func connectDB() (*sql.DB, error) {
+	return openDB(false)
+}
+
+// connectReadOnlyDB attaches the database READ_ONLY for commands that only
+// read history. It takes a shared lock instead of the write lock, so several
+// can run at once, but it cannot create the database or its tables.
+func connectReadOnlyDB() (*sql.DB, error) {
+	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
+		return nil, fmt.Errorf("database %s does not exist. Run 'init' first", dbFile)
+	}
+	return openDB(true)
+}
+
+func openDB(readOnly bool) (*sql.DB, error) {
 	db, err := sql.Open("duckdb", "")
 	if err != nil {
 		return nil, err
 	}
+	// USE and per-migration SET directives are per connection; keep a single
+	// one so they stick.
+	db.SetMaxOpenConns(1)
 
-	encKey := ""
-	if key := os.Getenv("ENC_KEY"); key != "" {
-		encKey = fmt.Sprintf("(ENCRYPTION_KEY '%s')", key)
+	var options []string
+	if readOnly {
+		options = append(options, "READ_ONLY")
+	}
+	if encryptionKey != "" {
+		options = append(options, fmt.Sprintf("ENCRYPTION_KEY '%s'", encryptionKey))
+	}
+	attachOptions := ""
+	if len(options) > 0 {
+		attachOptions = "(" + strings.Join(options, ", ") + ")"
 	}
 
 	attachQuery := fmt.Sprintf(
 		"USE memory; DETACH DATABASE IF EXISTS attached_db; ATTACH IF NOT EXISTS DATABASE '%s' AS attached_db %s; USE attached_db;",
-		dbFile, encKey,
+		dbFile, attachOptions,
 	)
-	if _, err = db.Exec(attachQuery); err != nil {
+	err = attachWithRetry(func() error {
+		_, err := db.ExecContext(rootCtx, attachQuery)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to attach database: %w", err)
+	}
+	for _, name := range sortedKeys(duckdbSettings) {
+		if _, err = db.ExecContext(rootCtx, fmt.Sprintf("SET %s = %s", name, quoteLiteral(duckdbSettings[name]))); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to set %s: %v", name, err)
+		}
+	}
+	if readOnly {
+		_, err = checkToolSchema(db)
+	} else if err = moveTrackingTables(db); err == nil {
+		err = upgradeToolSchema(db, false)
+	}
+	if err != nil {
 		_ = db.Close()
-		return nil, fmt.Errorf("failed to attach database: %v", err)
+		return nil, err
 	}
 	return db, nil
 }
 
-func isSyncTableInitialized() bool {
-	db, err := connectDB()
+// openScratchDB opens a throwaway in-memory database attached as attached_db,
+// so migrations that qualify names with attached_db behave as they do on disk.
+func openScratchDB() (*sql.DB, error) {
+	db, err := sql.Open("duckdb", "")
 	if err != nil {
-		fmt.Printf("Failed to connect to the database: %v\n", err)
-		return false
+		return nil, err
 	}
-	defer func() {
-		if err := db.Close(); err != nil {
-			fmt.Printf("Failed to close the database: %v\n", err)
+	// USE is per connection; keep a single one so it sticks.
+	db.SetMaxOpenConns(1)
+	if _, err = db.ExecContext(rootCtx, "ATTACH ':memory:' AS attached_db; USE attached_db;"); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to attach scratch database: %v", err)
+	}
+	return db, nil
+}
+
+// runInTx executes script and then record in one transaction, so a failed,
+// timed out or cancelled file leaves neither its changes nor a history row.
+// It returns how long script took.
+func runInTx(ctx context.Context, db *sql.DB, script string, record func(tx *sql.Tx, durationMs int64) error) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	_, err = tx.ExecContext(ctx, script)
+	durationMs := time.Since(start).Milliseconds()
+	if err == nil {
+		err = record(tx, durationMs)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return durationMs, err
+	}
+	return durationMs, tx.Commit()
+}
+
+// runStatementsInTx is runInTx for a script run one statement at a time. It
+// also returns the rows affected by each statement that succeeded, so on
+// failure the list stops before the failing statement.
+func runStatementsInTx(ctx context.Context, db *sql.DB, script string, record func(tx *sql.Tx, durationMs int64, rows []int64) error) (int64, []int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	durationMs, rows, err := execStatements(ctx, tx, script)
+	if err == nil {
+		err = record(tx, durationMs, rows)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return durationMs, rows, err
+	}
+	return durationMs, rows, tx.Commit()
+}
+
+// runStatements is runStatementsInTx without the transaction around the
+// script: each statement commits on its own, so the script may write to
+// several attached databases, and a failure keeps the statements before it.
+// Only record runs in a transaction, once every statement has succeeded.
+func runStatements(ctx context.Context, db *sql.DB, script string, record func(tx *sql.Tx, durationMs int64, rows []int64) error) (int64, []int64, error) {
+	durationMs, rows, err := execStatements(ctx, db, script)
+	if err != nil {
+		return durationMs, rows, err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return durationMs, rows, err
+	}
+	if err = record(tx, durationMs, rows); err != nil {
+		_ = tx.Rollback()
+		return durationMs, rows, err
+	}
+	return durationMs, rows, tx.Commit()
+}
+
+// execStatements runs script on db one statement at a time and returns how
+// long it took and the rows affected by each statement that succeeded.
+func execStatements(ctx context.Context, db execer, script string) (int64, []int64, error) {
+	start := time.Now()
+	rows := []int64{}
+	for _, stmt := range splitStatements(script) {
+		res, err := db.ExecContext(ctx, stmt)
+		if err != nil {
+			return time.Since(start).Milliseconds(), rows, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			n = 0
 		}
-	}()
-	_, err = db.Query(`SELECT name FROM sqlite_master WHERE type='table' AND name='sync'`)
-	return err == nil
+		rows = append(rows, n)
+	}
+	return time.Since(start).Milliseconds(), rows, nil
+}
+
+// hasTable reports whether the attached database has schema.table.
+func hasTable(db *sql.DB, schema, table string) (bool, error) {
+	var n int
+	err := db.QueryRowContext(rootCtx,
+		"SELECT count(*) FROM duckdb_tables() WHERE database_name = 'attached_db' AND schema_name = ? AND table_name = ?",
+		schema, table,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// hasColumn reports whether schema.table in the attached database has column.
+func hasColumn(db *sql.DB, schema, table, column string) (bool, error) {
+	var n int
+	err := db.QueryRowContext(rootCtx,
+		"SELECT count(*) FROM duckdb_columns() WHERE database_name = 'attached_db' AND schema_name = ? AND table_name = ? AND column_name = ?",
+		schema, table, column,
+	).Scan(&n)
+	return n > 0, err
 }
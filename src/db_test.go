@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -44,27 +45,40 @@ func TestConnectDB_RespectsEncKey(t *testing.T) {
 	db.Close()
 }
 
-func TestIsSyncTableInitialized_ReturnsFalseOnBadDB(t *testing.T) {
-	prev := dbFile
-	t.Cleanup(func() { dbFile = prev })
-	// Point at a path that cannot be created (invalid characters)
-	dbFile = string([]byte{0})
+func TestConnectReadOnlyDB(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "readonly.db"), dir)
 
-	result := isSyncTableInitialized()
-	if result {
-		t.Error("expected false when DB cannot be opened, got true")
+	if _, err := connectReadOnlyDB(); err == nil {
+		t.Fatal("expected an error for a database that does not exist")
+	}
+	if _, err := os.Stat(dbFile); !os.IsNotExist(err) {
+		t.Fatalf("read-only connect must not create the database: %v", err)
+	}
+	if code := showStatus(false); code != exitConnection {
+		t.Errorf("status without a database: want %d, got %d", exitConnection, code)
 	}
-}
 
-func TestIsSyncTableInitialized_ReturnsTrueWhenAccessible(t *testing.T) {
-	prev := dbFile
-	t.Cleanup(func() {
-		dbFile = prev
-		os.Remove("test_syncinit.db")
-	})
-	dbFile = "test_syncinit.db"
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+	if code := showStatus(false); code != exitFailure {
+		t.Errorf("status without the migrations table: want %d, got %d", exitFailure, code)
+	}
+	initialize()
 
-	if !isSyncTableInitialized() {
-		t.Error("expected true when DB is accessible, got false")
+	db, err = connectReadOnlyDB()
+	if err != nil {
+		t.Fatalf("connectReadOnlyDB() error = %v", err)
+	}
+	defer db.Close()
+	var n int
+	if err = db.QueryRow("SELECT count(*) FROM migrations").Scan(&n); err != nil {
+		t.Fatalf("read after connectReadOnlyDB failed: %v", err)
+	}
+	if _, err = db.Exec("CREATE TABLE t (id INTEGER)"); err == nil {
+		t.Error("expected writes to fail on a read-only connection")
 	}
 }
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// schemaChange is one step of a generated migration.
+type schemaChange struct {
+	phase       int
+	Up          string
+	Down        string
+	Note        string
+	Destructive bool
+}
+
+// Phases order the generated statements so dependents are dropped before the
+// objects they use and created after them.
+const (
+	phaseDropDependents = iota
+	phaseCreateBase
+	phaseTables
+	phaseDropTables
+	phaseDropBase
+	phaseCreateDependents
+)
+
+// diffSchema compares the current schema with the desired one in schemaPath
+// and writes a migration that moves the database to the desired state. With
+// check set, nothing is written and exitDrift is returned if they differ.
+func diffSchema(schemaPath string, fromMigrations bool, name string, dryRun, check bool) int {
+	desired, err := loadSchemaFile(schemaPath)
+	if err != nil {
+		logger.Error("Failed to load schema file", "path", schemaPath, "error", err)
+		return exitFailure
+	}
+
+	var current []schemaObject
+	source := dbFile
+	if fromMigrations {
+		source = migrationsDir
+		current, err = loadMigratedSchema()
+	} else {
+		current, err = loadDatabaseSchema()
+	}
+	if err != nil {
+		logger.Error("Failed to load current schema", "error", err)
+		return exitFailure
+	}
+
+	changes := diffSchemas(current, desired)
+	if len(changes) == 0 {
+		logger.Info("Schema is up to date", "path", schemaPath)
+		return exitOK
+	}
+
+	content := renderDiffMigration(changes, source, schemaPath)
+	for _, c := range changes {
+		if c.Destructive {
+			logger.Warn("Destructive change", "change", c.Note)
+		} else if c.Up == "" {
+			logger.Warn("Change needs review", "change", c.Note)
+		}
+	}
+
+	if check {
+		logger.Error("Schema differs from the schema file", "path", schemaPath, "changes", len(changes))
+		return exitDrift
+	}
+	if dryRun {
+		fmt.Print(content)
+		return exitOK
+	}
+
+	if err = os.MkdirAll(migrationsDir, os.ModePerm); err != nil {
+		logger.Error("Error creating migrations folder", "error", err)
+		return exitFailure
+	}
+	files, err := os.ReadDir(migrationsDir)
+	if err != nil {
+		logger.Error("Error reading migrations folder", "error", err)
+		return exitFailure
+	}
+	filePath := filepath.Join(migrationsDir, fmt.Sprintf("%03d_%s.sql", nextMigrationNumber(files), name))
+	if err = os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		logger.Error("Error creating migration file", "error", err)
+		return exitFailure
+	}
+	logger.Info("Migration created", "path", filePath, "changes", len(changes))
+	return exitOK
+}
+
+// loadSchemaFile replays a schema file in a scratch database and reads back
+// its catalog.
+func loadSchemaFile(path string) ([]schemaObject, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openScratchDB()
+	if err != nil {
+		return nil, err
+	}
+	defer func(db *sql.DB) { _ = db.Close() }(db)
+
+	if err = replayStatements(db, splitStatements(string(content))); err != nil {
+		return nil, err
+	}
+	return introspectSchema(db, "attached_db")
+}
+
+// loadMigratedSchema applies every migration to a scratch database and reads
+// back its catalog.
+func loadMigratedSchema() ([]schemaObject, error) {
+	files, err := listMigrationFiles(migrationsDir)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openScratchDB()
+	if err != nil {
+		return nil, err
+	}
+	defer func(db *sql.DB) { _ = db.Close() }(db)
+
+	if err = applyMigrationFiles(db, migrationsDir, files); err != nil {
+		return nil, err
+	}
+	return introspectSchema(db, "attached_db")
+}
+
+// loadDatabaseSchema reads the catalog of the attached database.
+func loadDatabaseSchema() ([]schemaObject, error) {
+	db, err := connectReadOnlyDB()
+	if err != nil {
+		return nil, err
+	}
+	defer func(db *sql.DB) { _ = db.Close() }(db)
+	return introspectSchema(db, "attached_db")
+}
+
+// replayStatements executes statements, retrying failed ones while others
+// still succeed, so files sorted by name rather than dependency still load.
+func replayStatements(db *sql.DB, statements []string) error {
+	pending := statements
+	for len(pending) > 0 {
+		var failed []string
+		var lastErr error
+		for _, stmt := range pending {
+			if _, err := db.ExecContext(rootCtx, stmt); err != nil {
+				failed = append(failed, stmt)
+				lastErr = err
+			}
+		}
+		if len(failed) == len(pending) {
+			return fmt.Errorf("%d statements could not be executed, first error: %v", len(failed), lastErr)
+		}
+		pending = failed
+	}
+	return nil
+}
+
+// diffSchemas returns the changes that turn current into desired.
+func diffSchemas(current, desired []schemaObject) []schemaChange {
+	key := func(obj schemaObject) string {
+		kind := obj.Kind
+		if kind == "table_macro" {
+			kind = "macro"
+		}
+		return kind + ":" + obj.Schema + "." + obj.Name
+	}
+	have := make(map[string]schemaObject, len(current))
+	for _, obj := range current {
+		have[key(obj)] = obj
+	}
+	want := make(map[string]schemaObject, len(desired))
+	for _, obj := range desired {
+		want[key(obj)] = obj
+	}
+
+	var changes []schemaChange
+	for _, obj := range desired {
+		cur, ok := have[key(obj)]
+		if !ok {
+			changes = append(changes, createChange(obj))
+			continue
+		}
+		changes = append(changes, alterChanges(cur, obj)...)
+	}
+	for _, obj := range current {
+		if _, ok := want[key(obj)]; !ok {
+			changes = append(changes, dropChange(obj))
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].phase < changes[j].phase })
+	return changes
+}
+
+func createChange(obj schemaObject) schemaChange {
+	phase := phaseCreateDependents
+	switch obj.Kind {
+	case "schema", "type", "sequence":
+		phase = phaseCreateBase
+	case "table":
+		phase = phaseTables
+	}
+	return schemaChange{
+		phase: phase,
+		Up:    obj.SQL,
+		Down:  strings.TrimSpace(renderDropDDL([]schemaObject{obj})),
+		Note:  fmt.Sprintf("creates %s %s", obj.Kind, qualifiedName(obj.Schema, obj.Name)),
+	}
+}
+
+func dropChange(obj schemaObject) schemaChange {
+	name := qualifiedName(obj.Schema, obj.Name)
+	change := schemaChange{
+		phase: phaseDropDependents,
+		Up:    strings.TrimSpace(strings.Replace(renderDropDDL([]schemaObject{obj}), " IF EXISTS", "", 1)),
+		Down:  obj.SQL,
+		Note:  fmt.Sprintf("drops %s %s", obj.Kind, name),
+	}
+	switch obj.Kind {
+	case "index":
+		change.Up = fmt.Sprintf("DROP INDEX %s;", name)
+	case "table":
+		change.phase = phaseDropTables
+		change.Destructive = true
+		change.Note = fmt.Sprintf("drops table %s and all its data; ROLLBACK recreates it empty", name)
+	case "schema", "type", "sequence":
+		change.phase = phaseDropBase
+		change.Destructive = obj.Kind == "sequence"
+	}
+	return change
+}
+
+// alterChanges compares two versions of the same object.
+func alterChanges(cur, want schemaObject) []schemaChange {
+	name := qualifiedName(want.Schema, want.Name)
+
+	switch want.Kind {
+	case "table":
+		return alterTableChanges(cur, want)
+	case "sequence":
+		if sequenceStartRe.ReplaceAllString(cur.SQL, "") == sequenceStartRe.ReplaceAllString(want.SQL, "") {
+			return nil
+		}
+		return []schemaChange{{phase: phaseCreateBase,
+			Note: fmt.Sprintf("sequence %s differs (%s); alter it manually", name, want.SQL)}}
+	}
+
+	if cur.SQL == want.SQL && cur.Kind == want.Kind {
+		return nil
+	}
+
+	switch want.Kind {
+	case "type":
+		return []schemaChange{{phase: phaseCreateBase,
+			Note: fmt.Sprintf("type %s differs (%s); columns using it must be migrated manually", name, want.SQL)}}
+	case "view", "macro", "table_macro":
+		if cur.Kind == want.Kind {
+			return []schemaChange{{
+				phase: phaseCreateDependents,
+				Up:    createOrReplace(want.SQL),
+				Down:  createOrReplace(cur.SQL),
+				Note:  fmt.Sprintf("replaces %s %s", want.Kind, name),
+			}}
+		}
+	}
+
+	drop := dropChange(cur)
+	create := createChange(want)
+	create.phase = phaseCreateDependents
+	return []schemaChange{drop, create}
+}
+
+// alterTableChanges emits ALTER TABLE statements for column differences and
+// flags other differences (constraints) for manual review.
+func alterTableChanges(cur, want schemaObject) []schemaChange {
+	name := qualifiedName(want.Schema, want.Name)
+	have := make(map[string]schemaColumn, len(cur.Columns))
+	for _, col := range cur.Columns {
+		have[col.Name] = col
+	}
+	wantCols := make(map[string]bool, len(want.Columns))
+
+	var changes []schemaChange
+	add := func(up, down, note string, destructive bool) {
+		changes = append(changes, schemaChange{phase: phaseTables, Up: up, Down: down, Note: note, Destructive: destructive})
+	}
+
+	for _, col := range want.Columns {
+		wantCols[col.Name] = true
+		column := quoteIdent(col.Name)
+		old, ok := have[col.Name]
+		if !ok {
+			add(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", name, columnDefinition(col)),
+				fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", name, column),
+				fmt.Sprintf("adds column %s.%s", name, column), false)
+			if !col.Nullable {
+				add(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL;", name, column),
+					fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL;", name, column),
+					fmt.Sprintf("makes %s.%s NOT NULL", name, column), false)
+			}
+			continue
+		}
+		if old.Type != col.Type {
+			add(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DATA TYPE %s;", name, column, col.Type),
+				fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DATA TYPE %s;", name, column, old.Type),
+				fmt.Sprintf("changes type of %s.%s from %s to %s; existing values are cast", name, column, old.Type, col.Type), true)
+		}
+		if old.Default != col.Default {
+			up := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT;", name, column)
+			if col.Default != "" {
+				up = fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s;", name, column, col.Default)
+			}
+			down := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT;", name, column)
+			if old.Default != "" {
+				down = fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s;", name, column, old.Default)
+			}
+			add(up, down, fmt.Sprintf("changes default of %s.%s", name, column), false)
+		}
+		if old.Nullable != col.Nullable {
+			set, unset := "DROP NOT NULL", "SET NOT NULL"
+			if !col.Nullable {
+				set, unset = unset, set
+			}
+			add(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s %s;", name, column, set),
+				fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s %s;", name, column, unset),
+				fmt.Sprintf("changes nullability of %s.%s", name, column), false)
+		}
+	}
+
+	for _, col := range cur.Columns {
+		if wantCols[col.Name] {
+			continue
+		}
+		column := quoteIdent(col.Name)
+		add(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", name, column),
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", name, columnDefinition(col)),
+			fmt.Sprintf("drops column %s.%s and its data; ROLLBACK re-adds it empty", name, column), true)
+	}
+
+	if len(changes) == 0 && cur.SQL != want.SQL {
+		changes = append(changes, schemaChange{phase: phaseTables,
+			Note: fmt.Sprintf("constraints of table %s differ (%s); DuckDB cannot alter them in place", name, want.SQL)})
+	}
+	return changes
+}
+
+func columnDefinition(col schemaColumn) string {
+	def := quoteIdent(col.Name) + " " + col.Type
+	if col.Default != "" {
+		def += " DEFAULT " + col.Default
+	}
+	return def
+}
+
+func createOrReplace(ddl string) string {
+	if strings.HasPrefix(ddl, "CREATE OR REPLACE ") {
+		return ddl
+	}
+	return strings.Replace(ddl, "CREATE ", "CREATE OR REPLACE ", 1)
+}
+
+// renderDiffMigration formats changes as a migration file. Changes that need
+// manual work are left as comments; destructive ones are marked.
+func renderDiffMigration(changes []schemaChange, from, to string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Generated by duckdbm diff from %s to %s. Review before applying.\n", from, to)
+	b.WriteString("-- MIGRATE\n")
+	for _, c := range changes {
+		switch {
+		case c.Up == "":
+			fmt.Fprintf(&b, "-- REVIEW: %s\n", c.Note)
+		case c.Destructive:
+			fmt.Fprintf(&b, "-- DESTRUCTIVE: %s\n%s\n", c.Note, c.Up)
+		default:
+			b.WriteString(c.Up + "\n")
+		}
+	}
+	b.WriteString("\n-- ROLLBACK\n")
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].Down != "" {
+			b.WriteString(changes[i].Down + "\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func schemaOf(t *testing.T, ddl string) []schemaObject {
+	t.Helper()
+	db, err := openScratchDB()
+	if err != nil {
+		t.Fatalf("openScratchDB: %v", err)
+	}
+	defer db.Close()
+	if ddl != "" {
+		if _, err = db.Exec(ddl); err != nil {
+			t.Fatalf("seed schema: %v", err)
+		}
+	}
+	objects, err := introspectSchema(db, "attached_db")
+	if err != nil {
+		t.Fatalf("introspectSchema: %v", err)
+	}
+	return objects
+}
+
+func TestDiffSchemas_NoChanges(t *testing.T) {
+	ddl := "CREATE TABLE users (id INTEGER, name TEXT); CREATE VIEW v AS SELECT id FROM users;"
+	if changes := diffSchemas(schemaOf(t, ddl), schemaOf(t, ddl)); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
+
+func TestDiffSchemas_GeneratesApplicableMigration(t *testing.T) {
+	currentDDL := `
+CREATE TABLE users (id INTEGER, name TEXT, legacy TEXT);
+CREATE TABLE old_stuff (id INTEGER);
+CREATE VIEW user_names AS SELECT name FROM users;`
+	desiredDDL := `
+CREATE SEQUENCE seq_orders;
+CREATE TABLE users (id INTEGER, name VARCHAR NOT NULL, email TEXT DEFAULT 'none');
+CREATE TABLE orders (id INTEGER DEFAULT nextval('seq_orders'), user_id INTEGER);
+CREATE VIEW user_names AS SELECT name, email FROM users;`
+
+	current := schemaOf(t, currentDDL)
+	desired := schemaOf(t, desiredDDL)
+	changes := diffSchemas(current, desired)
+	content := renderDiffMigration(changes, "test.db", "schema.sql")
+
+	for _, want := range []string{
+		`-- DESTRUCTIVE: drops table "old_stuff"`,
+		`-- DESTRUCTIVE: drops column "users"."legacy"`,
+		`ALTER TABLE "users" ADD COLUMN "email" VARCHAR DEFAULT 'none';`,
+		`ALTER TABLE "users" ALTER COLUMN "name" SET NOT NULL;`,
+		"CREATE OR REPLACE VIEW user_names",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("migration misses %q:\n%s", want, content)
+		}
+	}
+
+	// Applying the migration must yield the desired schema, and its
+	// ROLLBACK must bring back the original one.
+	db, err := openScratchDB()
+	if err != nil {
+		t.Fatalf("openScratchDB: %v", err)
+	}
+	defer db.Close()
+	if _, err = db.Exec(currentDDL); err != nil {
+		t.Fatalf("seed current: %v", err)
+	}
+	parts := strings.Split(content, "-- ROLLBACK")
+	if _, err = db.Exec(parts[0]); err != nil {
+		t.Fatalf("apply generated migration: %v\n%s", err, content)
+	}
+	after, err := introspectSchema(db, "attached_db")
+	if err != nil {
+		t.Fatalf("introspectSchema: %v", err)
+	}
+	if left := diffSchemas(after, desired); len(left) != 0 {
+		t.Errorf("schema still differs after migration: %+v", left)
+	}
+	if _, err = db.Exec(parts[1]); err != nil {
+		t.Fatalf("apply generated rollback: %v\n%s", err, content)
+	}
+	restored, err := introspectSchema(db, "attached_db")
+	if err != nil {
+		t.Fatalf("introspectSchema: %v", err)
+	}
+	if left := diffSchemas(restored, current); len(left) != 0 {
+		t.Errorf("schema differs after rollback: %+v", left)
+	}
+}
+
+func TestDiffSchemas_FlagsConstraintChanges(t *testing.T) {
+	changes := diffSchemas(
+		schemaOf(t, "CREATE TABLE t (id INTEGER, CHECK (id > 0));"),
+		schemaOf(t, "CREATE TABLE t (id INTEGER, CHECK (id > 1));"),
+	)
+	if len(changes) != 1 || changes[0].Up != "" || !strings.Contains(changes[0].Note, "constraints") {
+		t.Errorf("expected a single review note, got %+v", changes)
+	}
+}
+
+func TestReplayStatements_OutOfOrder(t *testing.T) {
+	db, err := openScratchDB()
+	if err != nil {
+		t.Fatalf("openScratchDB: %v", err)
+	}
+	defer db.Close()
+
+	err = replayStatements(db, []string{
+		"CREATE VIEW a_view AS SELECT * FROM z_table",
+		"CREATE TABLE z_table (id INTEGER)",
+	})
+	if err != nil {
+		t.Errorf("replayStatements: %v", err)
+	}
+	if err = replayStatements(db, []string{"CREATE TABLE broken ("}); err == nil {
+		t.Error("expected error for invalid statement")
+	}
+}
+
+func TestDiffSchema_WritesMigrationFromMigrations(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_diff.db", dir)
+
+	if err := os.WriteFile(filepath.Join(dir, "001_users.sql"),
+		[]byte("-- MIGRATE\nCREATE TABLE users (id INTEGER);\n-- ROLLBACK\nDROP TABLE users;\n"), 0644); err != nil {
+		t.Fatalf("write migration: %v", err)
+	}
+	schemaPath := filepath.Join(t.TempDir(), "schema.sql")
+	if err := os.WriteFile(schemaPath, []byte("CREATE TABLE users(id INTEGER, email VARCHAR);\n"), 0644); err != nil {
+		t.Fatalf("write schema: %v", err)
+	}
+
+	diffSchema(schemaPath, true, "add_email", false, false)
+
+	data, err := os.ReadFile(filepath.Join(dir, "002_add_email.sql"))
+	if err != nil {
+		t.Fatalf("migration not written: %v", err)
+	}
+	if !strings.Contains(string(data), `ADD COLUMN "email" VARCHAR`) {
+		t.Errorf("unexpected migration:\n%s", data)
+	}
+}
+
+func TestDiffSchema_DatabaseIsReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "missing.db"), dir)
+	schemaPath := filepath.Join(dir, "schema.sql")
+	if err := os.WriteFile(schemaPath, []byte("CREATE TABLE users(id INTEGER);\n"), 0644); err != nil {
+		t.Fatalf("write schema: %v", err)
+	}
+
+	if code := diffSchema(schemaPath, false, "add_users", true, false); code == exitOK {
+		t.Error("diff against a missing database should fail")
+	}
+	if _, err := os.Stat(dbFile); !os.IsNotExist(err) {
+		t.Errorf("diff must not create %s: %v", dbFile, err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/joho/godotenv"
+)
+
+// envFiles holds the --env-file values in the order given.
+var envFiles []string
+
+// loadedEnvFiles lists the .env files loaded for this run, lowest precedence
+// first.
+var loadedEnvFiles []string
+
+// loadEnvFiles layers .env, .env.<env>, .env.local and then each explicit
+// --env-file into the process environment. Later files override earlier
+// ones, and variables already set in the process environment override all of
+// them. Only the explicit files must exist. It returns the files loaded.
+func loadEnvFiles(env string, explicit []string) ([]string, error) {
+	files := []string{".env"}
+	if env != "" {
+		files = append(files, ".env."+env)
+	}
+	files = append(files, ".env.local")
+	auto := len(files)
+	files = append(files, explicit...)
+
+	merged := map[string]string{}
+	var loaded []string
+	for i, path := range files {
+		values, err := godotenv.Read(path)
+		if os.IsNotExist(err) && i < auto {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load env file %s: %v", path, err)
+		}
+		for k, v := range values {
+			merged[k] = v
+		}
+		loaded = append(loaded, path)
+	}
+
+	for k, v := range merged {
+		if _, ok := os.LookupEnv(k); !ok {
+			if err := os.Setenv(k, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return loaded, nil
+}
+
+// unloadedEnvFile returns an .env file in the working directory that was not
+// loaded for this run but defines name, or "" if there is none. It explains
+// unset macro variables such as a value only present in .env.prod.
+func unloadedEnvFile(name string) string {
+	matches, _ := filepath.Glob(".env*")
+	sort.Strings(matches)
+	for _, path := range matches {
+		if isLoadedEnvFile(path) {
+			continue
+		}
+		values, err := godotenv.Read(path)
+		if err != nil {
+			continue
+		}
+		if _, ok := values[name]; ok {
+			return path
+		}
+	}
+	return ""
+}
+
+func isLoadedEnvFile(path string) bool {
+	for _, loaded := range loadedEnvFiles {
+		if filepath.Clean(loaded) == filepath.Clean(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func writeEnvFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadEnvFiles_Precedence(t *testing.T) {
+	writeEnvFiles(t, map[string]string{
+		".env":       "ENVFILE_A=base\nENVFILE_B=base\nENVFILE_C=base\nENVFILE_D=base\nENVFILE_SHELL=base\n",
+		".env.prod":  "ENVFILE_B=prod\nENVFILE_C=prod\nENVFILE_D=prod\n",
+		".env.local": "ENVFILE_C=local\nENVFILE_D=local\n",
+		"extra.env":  "ENVFILE_D=extra\n",
+		".env.dev":   "ENVFILE_A=dev\n",
+	})
+	for _, k := range []string{"ENVFILE_A", "ENVFILE_B", "ENVFILE_C", "ENVFILE_D"} {
+		t.Setenv(k, "")
+		os.Unsetenv(k)
+	}
+	t.Setenv("ENVFILE_SHELL", "shell")
+
+	loaded, err := loadEnvFiles("prod", []string{"extra.env"})
+	if err != nil {
+		t.Fatalf("loadEnvFiles: %v", err)
+	}
+	if want := []string{".env", ".env.prod", ".env.local", "extra.env"}; !reflect.DeepEqual(loaded, want) {
+		t.Errorf("loaded: want %v, got %v", want, loaded)
+	}
+	for k, want := range map[string]string{
+		"ENVFILE_A":     "base",
+		"ENVFILE_B":     "prod",
+		"ENVFILE_C":     "local",
+		"ENVFILE_D":     "extra",
+		"ENVFILE_SHELL": "shell",
+	} {
+		if got := os.Getenv(k); got != want {
+			t.Errorf("%s: want %q, got %q", k, want, got)
+		}
+	}
+}
+
+func TestLoadEnvFiles_MissingExplicitFile(t *testing.T) {
+	writeEnvFiles(t, nil)
+	if loaded, err := loadEnvFiles("dev", nil); err != nil || len(loaded) != 0 {
+		t.Errorf("missing automatic files should be skipped: %v, %v", loaded, err)
+	}
+	if _, err := loadEnvFiles("", []string{"nope.env"}); err == nil {
+		t.Error("expected an error for a missing --env-file")
+	}
+}
+
+func TestUnloadedEnvFile(t *testing.T) {
+	writeEnvFiles(t, map[string]string{
+		".env":      "ENVFILE_HOST=localhost\n",
+		".env.prod": "ENVFILE_PASSWORD=secret\n",
+	})
+	prev := loadedEnvFiles
+	t.Cleanup(func() { loadedEnvFiles = prev })
+	loadedEnvFiles = []string{".env"}
+
+	if got := unloadedEnvFile("ENVFILE_PASSWORD"); got != ".env.prod" {
+		t.Errorf("want .env.prod, got %q", got)
+	}
+	if got := unloadedEnvFile("ENVFILE_HOST"); got != "" {
+		t.Errorf("a variable from a loaded file should not be reported, got %q", got)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Exit codes returned by every command. They are part of the CLI contract
+// documented in the user guide; do not renumber them.
+const (
+	exitOK = 0
+	// exitFailure: a migration, rollback, sync, validation or lint failure,
+	// or any other error not covered below.
+	exitFailure = 1
+	// exitUsage: unknown command, bad flag or missing argument.
+	exitUsage = 2
+	// exitConnection: the database could not be opened or attached.
+	exitConnection = 3
+	// exitLockTimeout: the database file stayed locked by another process
+	// for longer than --lock-wait.
+	exitLockTimeout = 4
+	// exitDrift: the database or schema file differs from what the
+	// migrations describe.
+	exitDrift = 5
+	// exitPending: there are migrations that have not been applied.
+	exitPending = 6
+	// exitCancelled: a migration, rollback or sync was interrupted by
+	// SIGINT or SIGTERM, or ran past its timeout, and was rolled back.
+	exitCancelled = 7
+)
+
+// connectionExitCode classifies an error from connectDB.
+func connectionExitCode(err error) int {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitCancelled
+	case isLockError(err):
+		return exitLockTimeout
+	}
+	return exitConnection
+}
+
+// isLockError reports whether err is DuckDB refusing to open a file that
+// another process holds a lock on.
+func isLockError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "Could not set lock") || strings.Contains(msg, "Conflicting lock")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConnectionExitCode(t *testing.T) {
+	lock := errors.New(`IO Error: Could not set lock on file "data.db": Conflicting lock is held in /usr/bin/duckdbm (PID 4242)`)
+	if code := connectionExitCode(lock); code != exitLockTimeout {
+		t.Errorf("lock error: want %d, got %d", exitLockTimeout, code)
+	}
+	if code := connectionExitCode(errors.New("IO Error: Cannot open file")); code != exitConnection {
+		t.Errorf("other error: want %d, got %d", exitConnection, code)
+	}
+}
+
+func TestApplyMigrations_ExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_exit_apply.db", dir)
+	initialize()
+
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("001_ok.sql", "-- MIGRATE\nCREATE TABLE ok (id INTEGER);\n-- ROLLBACK\nDROP TABLE ok;")
+
+	if code := showStatus(true); code != exitPending {
+		t.Errorf("status --check with a pending migration: want %d, got %d", exitPending, code)
+	}
+	if code := applyMigrations(); code != exitOK {
+		t.Errorf("apply: want %d, got %d", exitOK, code)
+	}
+	if code := showStatus(true); code != exitOK {
+		t.Errorf("status --check when up to date: want %d, got %d", exitOK, code)
+	}
+
+	write("002_bad.sql", "-- MIGRATE\nCREATE TABLE broken (id NOSUCHTYPE);")
+	if code := applyMigrations(); code != exitFailure {
+		t.Errorf("apply of a failing migration: want %d, got %d", exitFailure, code)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "001_ok.sql")); err != nil {
+		t.Fatal(err)
+	}
+	if code := showStatus(true); code != exitDrift {
+		t.Errorf("status --check with a missing file: want %d, got %d", exitDrift, code)
+	}
+}
+
+func TestSyncMigration_ExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_exit_sync.db", dir)
+	initialize()
+
+	if code := syncMigration("missing"); code != exitUsage {
+		t.Errorf("unknown sync file: want %d, got %d", exitUsage, code)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bad.sql"), []byte("-- MIGRATE\nINSERT INTO nowhere VALUES (1);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := syncMigration("bad"); code != exitFailure {
+		t.Errorf("failing sync: want %d, got %d", exitFailure, code)
+	}
+}
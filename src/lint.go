@@ -0,0 +1,414 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Lint severities, from most to least severe.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityOff     = "off"
+)
+
+// lintIgnorePrefix starts a comment that disables rules for a whole file,
+// e.g. "-- lint:ignore drop-table truncate".
+const lintIgnorePrefix = "lint:ignore"
+
+// allowDestructive lets apply run migrations that trip a destructive rule.
+var allowDestructive bool
+
+// lintFile is a migration or sync file prepared for linting.
+type lintFile struct {
+	Name     string
+	Content  string
+	Sync     bool
+	Migrate  []sqlStatement
+	Rollback []sqlStatement
+	// RollbackLine is the line of the -- ROLLBACK marker, 0 if there is none.
+	RollbackLine int
+	Ignored      map[string]bool
+}
+
+// lintIssue is a problem reported by a rule, before severity is applied.
+type lintIssue struct {
+	Line    int
+	Message string
+}
+
+// lintFinding is a lintIssue attributed to a file and rule.
+type lintFinding struct {
+	File     string
+	Line     int
+	Rule     string
+	Severity string
+	Message  string
+}
+
+// lintRule checks a file. Destructive rules also gate apply.
+type lintRule struct {
+	ID          string
+	Severity    string
+	Destructive bool
+	// Description is the rule's summary in SARIF reports.
+	Description string
+	Check       func(f *lintFile) []lintIssue
+}
+
+var (
+	dropColumnRe    = regexp.MustCompile(`^ALTER TABLE (IF EXISTS )?\S+ DROP (COLUMN )?(IF EXISTS )?\S+$`)
+	createTableRe   = regexp.MustCompile(`^CREATE (TEMP |TEMPORARY )?TABLE `)
+	whereClauseRe   = regexp.MustCompile(`\bWHERE\b`)
+	whitespaceRe    = regexp.MustCompile(`\s+`)
+	lintIgnoreSepRe = regexp.MustCompile(`[\s,]+`)
+)
+
+var lintRules = []lintRule{
+	{
+		ID: "drop-table", Severity: severityError, Destructive: true,
+		Description: "Dropping a table in a migration destroys its data.",
+		Check: migrateStatementRule(func(stmt string) bool { return strings.HasPrefix(stmt, "DROP TABLE ") },
+			"DROP TABLE in MIGRATE section destroys data"),
+	},
+	{
+		ID: "drop-column", Severity: severityError, Destructive: true,
+		Description: "Dropping a column in a migration destroys its data.",
+		Check: migrateStatementRule(func(stmt string) bool { return dropColumnRe.MatchString(stmt) },
+			"DROP COLUMN in MIGRATE section destroys data"),
+	},
+	{
+		ID: "truncate", Severity: severityError, Destructive: true,
+		Description: "Truncating a table in a migration destroys its data.",
+		Check: migrateStatementRule(func(stmt string) bool { return strings.HasPrefix(stmt, "TRUNCATE ") },
+			"TRUNCATE in MIGRATE section destroys data"),
+	},
+	{
+		ID: "missing-rollback", Severity: severityWarning,
+		Description: "Migrations should have a ROLLBACK section.",
+		Check: func(f *lintFile) []lintIssue {
+			if f.Sync {
+				return nil
+			}
+			if f.RollbackLine == 0 {
+				return []lintIssue{{Line: 1, Message: "migration has no ROLLBACK section"}}
+			}
+			if len(f.Rollback) == 0 {
+				return []lintIssue{{Line: f.RollbackLine, Message: "ROLLBACK section is empty"}}
+			}
+			return nil
+		},
+	},
+	{
+		ID: "sync-create-table", Severity: severityError,
+		Description: "Sync files run repeatedly and must not create tables.",
+		Check: func(f *lintFile) []lintIssue {
+			if !f.Sync {
+				return nil
+			}
+			var issues []lintIssue
+			for _, stmt := range f.Migrate {
+				norm := normalizeStatement(stmt.SQL)
+				if createTableRe.MatchString(norm) && !strings.Contains(norm, " TABLE IF NOT EXISTS ") {
+					issues = append(issues, lintIssue{stmt.Line, "CREATE TABLE without IF NOT EXISTS fails when the sync runs again"})
+				}
+			}
+			return issues
+		},
+	},
+	{
+		ID: "delete-without-where", Severity: severityWarning,
+		Description: "DELETE without WHERE removes every row.",
+		Check: migrateStatementRule(func(stmt string) bool {
+			return strings.HasPrefix(stmt, "DELETE ") && !whereClauseRe.MatchString(stmt)
+		}, "DELETE without WHERE removes every row"),
+	},
+	{
+		ID: "update-without-where", Severity: severityWarning,
+		Description: "UPDATE without WHERE changes every row.",
+		Check: migrateStatementRule(func(stmt string) bool {
+			return strings.HasPrefix(stmt, "UPDATE ") && !whereClauseRe.MatchString(stmt)
+		}, "UPDATE without WHERE changes every row"),
+	},
+	{
+		ID: "macro-in-string", Severity: severityWarning,
+		Description: "Macros inside string literals are substituted without escaping.",
+		Check: func(f *lintFile) []lintIssue {
+			var issues []lintIssue
+			for _, m := range macrosInStrings(f.Content) {
+				issues = append(issues, lintIssue{m.Line, fmt.Sprintf(
+					"macro {{%s}} inside a quoted string is not escaped; a quote in its value breaks the statement", m.Name)})
+			}
+			return issues
+		},
+	},
+}
+
+// migrateStatementRule builds a check that flags MIGRATE statements for
+// which match returns true. match receives the normalized statement.
+func migrateStatementRule(match func(stmt string) bool, message string) func(f *lintFile) []lintIssue {
+	return func(f *lintFile) []lintIssue {
+		var issues []lintIssue
+		for _, stmt := range f.Migrate {
+			if match(normalizeStatement(stmt.SQL)) {
+				issues = append(issues, lintIssue{stmt.Line, message})
+			}
+		}
+		return issues
+	}
+}
+
+// lintMigrations lints every .sql file in dir whose name contains target.
+func lintMigrations(dir, target string, sync bool, severities map[string]string) ([]lintFinding, error) {
+	files, err := listMigrationFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []lintFinding
+	for _, filename := range files {
+		if target != "" && !strings.Contains(filename, target) {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, filename))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", filename, err)
+		}
+		findings = append(findings, lintContent(filename, string(content), sync, severities)...)
+	}
+	return findings, nil
+}
+
+// lintContent runs every rule against a single file.
+func lintContent(filename, content string, sync bool, severities map[string]string) []lintFinding {
+	f := parseLintFile(filename, content, sync)
+
+	var findings []lintFinding
+	for _, rule := range lintRules {
+		severity := rule.Severity
+		if s, ok := severities[rule.ID]; ok {
+			severity = s
+		}
+		if severity == severityOff || f.Ignored[rule.ID] || f.Ignored["all"] {
+			continue
+		}
+		for _, issue := range rule.Check(f) {
+			findings = append(findings, lintFinding{
+				File:     filename,
+				Line:     issue.Line,
+				Rule:     rule.ID,
+				Severity: severity,
+				Message:  issue.Message,
+			})
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Line < findings[j].Line })
+	return findings
+}
+
+// destructiveFindings returns the findings of destructive rules for a
+// migration, honoring lint:ignore comments.
+func destructiveFindings(filename, content string) []lintFinding {
+	var destructive []lintFinding
+	for _, finding := range lintContent(filename, content, false, nil) {
+		for _, rule := range lintRules {
+			if rule.ID == finding.Rule && rule.Destructive {
+				destructive = append(destructive, finding)
+			}
+		}
+	}
+	return destructive
+}
+
+func parseLintFile(filename, content string, sync bool) *lintFile {
+	f := &lintFile{Name: filename, Content: content, Sync: sync, Ignored: make(map[string]bool)}
+
+	parts := strings.SplitN(content, "-- ROLLBACK", 2)
+	f.Migrate = splitStatementLines(parts[0], 1)
+	if len(parts) > 1 {
+		f.RollbackLine = strings.Count(parts[0], "\n") + 1
+		f.Rollback = splitStatementLines(parts[1], f.RollbackLine)
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "--") {
+			continue
+		}
+		comment := strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		if !strings.HasPrefix(comment, lintIgnorePrefix) {
+			continue
+		}
+		for _, id := range lintIgnoreSepRe.Split(strings.TrimPrefix(comment, lintIgnorePrefix), -1) {
+			if id != "" {
+				f.Ignored[id] = true
+			}
+		}
+	}
+	return f
+}
+
+// normalizeStatement strips comments and literals from stmt, collapses
+// whitespace and upper-cases it, so rules can match on keywords only.
+func normalizeStatement(stmt string) string {
+	var b strings.Builder
+	for i := 0; i < len(stmt); i++ {
+		c := stmt[i]
+		switch {
+		case c == '-' && i+1 < len(stmt) && stmt[i+1] == '-':
+			for i < len(stmt) && stmt[i] != '\n' {
+				i++
+			}
+			b.WriteByte(' ')
+		case c == '/' && i+1 < len(stmt) && stmt[i+1] == '*':
+			end := strings.Index(stmt[i+2:], "*/")
+			if end < 0 {
+				i = len(stmt)
+			} else {
+				i += end + 3
+			}
+			b.WriteByte(' ')
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(stmt[i+1:], c)
+			if end < 0 {
+				i = len(stmt)
+			} else {
+				i += end + 1
+			}
+			if c == '\'' {
+				b.WriteString("''")
+			} else {
+				b.WriteString("X")
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(whitespaceRe.ReplaceAllString(strings.ToUpper(b.String()), " "))
+}
+
+type macroUse struct {
+	Name string
+	Line int
+}
+
+// macrosInStrings finds {{VAR}} macros that appear inside single-quoted
+// string literals, outside of comments.
+func macrosInStrings(content string) []macroUse {
+	var uses []macroUse
+	scanSQL(content, func(kind, start, end int) {
+		if kind != spanString {
+			return
+		}
+		for _, m := range macroRe.FindAllStringSubmatchIndex(content[start:end], -1) {
+			uses = append(uses, macroUse{
+				Name: content[start+m[2] : start+m[3]],
+				Line: 1 + strings.Count(content[:start+m[0]], "\n"),
+			})
+		}
+	})
+	return uses
+}
+
+// printLintFindings prints findings in the validate style and reports
+// whether any of them is an error.
+func printLintFindings(findings []lintFinding) bool {
+	hasErrors := false
+	for _, f := range findings {
+		mark := "!"
+		if f.Severity == severityError {
+			mark = "✗"
+			hasErrors = true
+		}
+		fmt.Printf("  %s %s:%d [%s] %s\n", mark, f.File, f.Line, f.Rule, f.Message)
+	}
+	return hasErrors
+}
+
+// runLint lints the migrations and sync directories, writes a SARIF report
+// when requested with --report and returns exitFailure when a finding has
+// error severity.
+func runLint(target string, severities map[string]string) int {
+	hasErrors := false
+	total := 0
+	var reported []lintFinding
+	for _, d := range []struct {
+		dir  string
+		sync bool
+	}{{migrationsDir, false}, {syncDirectory(), true}} {
+		if _, err := os.Stat(d.dir); d.sync && os.IsNotExist(err) {
+			continue
+		}
+		fmt.Println("Linting migrations... " + d.dir)
+		findings, err := lintMigrations(d.dir, target, d.sync, severities)
+		if err != nil {
+			logger.Error("Failed to lint", "dir", d.dir, "error", err)
+			return exitFailure
+		}
+		if printLintFindings(findings) {
+			hasErrors = true
+		}
+		total += len(findings)
+		for _, f := range findings {
+			f.File = filepath.Join(d.dir, f.File)
+			reported = append(reported, f)
+		}
+	}
+
+	if path := lintReports.path(reportSARIF); path != "" {
+		if err := writeSARIFReport(path, reported); err != nil {
+			logger.Error("Failed to write SARIF report", "path", path, "error", err)
+			return exitFailure
+		}
+	}
+
+	if hasErrors {
+		fmt.Println("\nLint failed.")
+		return exitFailure
+	}
+	if total > 0 {
+		fmt.Printf("\nLint passed with %d warnings.\n", total)
+		return exitOK
+	}
+	fmt.Println("\nNo lint findings.")
+	return exitOK
+}
+
+// severityFlag collects repeatable --severity rule=level flags.
+type severityFlag map[string]string
+
+func (s severityFlag) String() string {
+	var parts []string
+	for rule, level := range s {
+		parts = append(parts, rule+"="+level)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (s severityFlag) Set(value string) error {
+	rule, level, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected rule=level, got %q", value)
+	}
+	switch level {
+	case severityError, severityWarning, severityOff:
+	default:
+		return fmt.Errorf("unknown severity %q (use error, warning or off)", level)
+	}
+	known := false
+	for _, r := range lintRules {
+		if r.ID == rule {
+			known = true
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown lint rule %q", rule)
+	}
+	s[rule] = level
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func findingRules(findings []lintFinding) string {
+	var rules []string
+	for _, f := range findings {
+		rules = append(rules, f.Rule)
+	}
+	return strings.Join(rules, ",")
+}
+
+func TestLintContent_Rules(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		sync    bool
+		want    string
+	}{
+		{"clean", "-- MIGRATE\nCREATE TABLE a (id INTEGER);\n-- ROLLBACK\nDROP TABLE a;\n", false, ""},
+		{"drop table", "-- MIGRATE\nDROP TABLE a;\n-- ROLLBACK\nCREATE TABLE a (id INTEGER);\n", false, "drop-table"},
+		{"drop column", "-- MIGRATE\nALTER TABLE a DROP COLUMN b;\n-- ROLLBACK\nALTER TABLE a ADD COLUMN b INTEGER;\n", false, "drop-column"},
+		{"drop not null is fine", "-- MIGRATE\nALTER TABLE a ALTER COLUMN b DROP NOT NULL;\n-- ROLLBACK\nSELECT 1;\n", false, ""},
+		{"truncate", "-- MIGRATE\nTRUNCATE TABLE a;\n-- ROLLBACK\nSELECT 1;\n", false, "truncate"},
+		{"missing rollback", "-- MIGRATE\nCREATE TABLE a (id INTEGER);\n", false, "missing-rollback"},
+		{"empty rollback", "-- MIGRATE\nCREATE TABLE a (id INTEGER);\n-- ROLLBACK\n-- nothing\n", false, "missing-rollback"},
+		{"sync without rollback", "-- MIGRATE\nINSERT INTO a SELECT 1;\n", true, ""},
+		{"sync create table", "-- MIGRATE\nCREATE TABLE a (id INTEGER);\n", true, "sync-create-table"},
+		{"sync create table if not exists", "-- MIGRATE\nCREATE TABLE IF NOT EXISTS a (id INTEGER);\nCREATE OR REPLACE TABLE b AS SELECT 1;\n", true, ""},
+		{"delete without where", "-- MIGRATE\nDELETE FROM a;\nDELETE FROM b WHERE id = 1;\n-- ROLLBACK\nSELECT 1;\n", false, "delete-without-where"},
+		{"update without where", "-- MIGRATE\nUPDATE a SET x = 'WHERE';\n-- ROLLBACK\nSELECT 1;\n", false, "update-without-where"},
+		{"macro in string", "-- MIGRATE\nATTACH '{{DB_PATH}}' AS src;\nCREATE TABLE {{TABLE}} (id INTEGER);\n-- ROLLBACK\nDETACH src;\n", false, "macro-in-string"},
+		{"keywords inside strings", "-- MIGRATE\nSELECT 'DROP TABLE a';\n-- DROP TABLE b;\n-- ROLLBACK\nSELECT 1;\n", false, ""},
+		{"ignore comment", "-- lint:ignore drop-table, missing-rollback\n-- MIGRATE\nDROP TABLE a;\n", false, ""},
+		{"ignore all", "-- lint:ignore all\n-- MIGRATE\nDROP TABLE a;\n", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findingRules(lintContent("f.sql", tt.content, tt.sync, nil)); got != tt.want {
+				t.Errorf("want rules %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLintContent_LinesAndSeverity(t *testing.T) {
+	content := "-- MIGRATE\nCREATE TABLE a (id INTEGER);\n\nDROP TABLE b;\n-- ROLLBACK\nSELECT 1;\n"
+
+	findings := lintContent("f.sql", content, false, nil)
+	if len(findings) != 1 || findings[0].Line != 4 || findings[0].Severity != severityError {
+		t.Fatalf("expected drop-table error on line 4, got %+v", findings)
+	}
+
+	findings = lintContent("f.sql", content, false, map[string]string{"drop-table": severityWarning})
+	if len(findings) != 1 || findings[0].Severity != severityWarning {
+		t.Errorf("expected severity override to warning, got %+v", findings)
+	}
+
+	findings = lintContent("f.sql", content, false, map[string]string{"drop-table": severityOff})
+	if len(findings) != 0 {
+		t.Errorf("expected rule turned off, got %+v", findings)
+	}
+}
+
+func TestMacrosInStrings_Lines(t *testing.T) {
+	content := "-- '{{IN_COMMENT}}'\nSELECT '{{A}}',\n  'x\n{{B}}', {{C}};"
+	uses := macrosInStrings(content)
+	if len(uses) != 2 {
+		t.Fatalf("expected 2 macros in strings, got %+v", uses)
+	}
+	if uses[0].Name != "A" || uses[0].Line != 2 || uses[1].Name != "B" || uses[1].Line != 4 {
+		t.Errorf("unexpected macro uses: %+v", uses)
+	}
+}
+
+func TestMacrosInStrings_CommentsAndEscapes(t *testing.T) {
+	content := "/* SELECT '{{IN_BLOCK}}';\n*/\nSELECT 'it''s {{A}}', \"it's\", {{B}}, 'x'"
+	uses := macrosInStrings(content)
+	if len(uses) != 1 || uses[0].Name != "A" || uses[0].Line != 3 {
+		t.Errorf("expected only A on line 3, got %+v", uses)
+	}
+}
+
+func TestSeverityFlag_Set(t *testing.T) {
+	s := severityFlag{}
+	if err := s.Set("drop-table=warning"); err != nil || s["drop-table"] != severityWarning {
+		t.Errorf("valid value rejected: %v", err)
+	}
+	for _, bad := range []string{"drop-table", "drop-table=fatal", "no-such-rule=off"} {
+		if err := s.Set(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestApplyMigrations_RequiresAllowDestructive(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_destructive.db", dir)
+	t.Cleanup(func() { allowDestructive = false })
+
+	files := map[string]string{
+		"001_create.sql": "-- MIGRATE\nCREATE TABLE doomed (id INTEGER);\n-- ROLLBACK\nDROP TABLE doomed;\n",
+		"002_drop.sql":   "-- MIGRATE\nDROP TABLE doomed;\n-- ROLLBACK\nCREATE TABLE doomed (id INTEGER);\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	count := func() int {
+		db, err := connectDB()
+		if err != nil {
+			t.Fatalf("connectDB: %v", err)
+		}
+		defer db.Close()
+		var n int
+		if err = db.QueryRow("SELECT COUNT(*) FROM attached_db.migrations").Scan(&n); err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		return n
+	}
+
+	initialize()
+	applyMigrations()
+	if n := count(); n != 1 {
+		t.Fatalf("expected destructive migration to be held back, got %d applied", n)
+	}
+
+	allowDestructive = true
+	applyMigrations()
+	if n := count(); n != 2 {
+		t.Errorf("expected destructive migration applied with --allow-destructive, got %d applied", n)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// lockWait is how long connectDB keeps retrying while another process holds
+// the database file; 0 fails at the first conflict.
+var lockWait time.Duration
+
+// lockBackoff is the delay before the first retry. It doubles after every
+// attempt, up to maxLockBackoff.
+var lockBackoff = defaultLockBackoff
+
+const (
+	defaultLockBackoff = 250 * time.Millisecond
+	maxLockBackoff     = 5 * time.Second
+)
+
+// lockPIDRe matches the process DuckDB names in a lock conflict, as in
+// "Conflicting lock is held in /usr/bin/duckdbm (PID 4242)".
+var lockPIDRe = regexp.MustCompile(`\(PID (\d+)\)`)
+
+// lockHolderPID returns the PID holding the lock in a DuckDB lock error, or 0
+// if the message does not include one.
+func lockHolderPID(err error) int {
+	m := lockPIDRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(m[1])
+	return pid
+}
+
+// attachWithRetry calls attach until it succeeds, fails with anything other
+// than a lock conflict, lockWait runs out or rootCtx is cancelled.
+func attachWithRetry(attach func() error) error {
+	deadline := time.Now().Add(lockWait)
+	delay := lockBackoff
+	for attempt := 1; ; attempt++ {
+		err := attach()
+		if err == nil || !isLockError(err) {
+			return err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			if attempt > 1 {
+				return fmt.Errorf("%v (gave up after %d attempts over %s)", err, attempt, lockWait)
+			}
+			return err
+		}
+		delay = min(delay, remaining)
+
+		attrs := []any{"attempt", attempt, "retry_in", delay.Round(time.Millisecond)}
+		if pid := lockHolderPID(err); pid != 0 {
+			attrs = append(attrs, "pid", pid)
+		}
+		logger.Warn("Database is locked by another process, retrying", attrs...)
+
+		select {
+		case <-rootCtx.Done():
+			return fmt.Errorf("%w while waiting for the database lock: %v", rootCtx.Err(), err)
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxLockBackoff)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testLockError = `IO Error: Could not set lock on file "data.db": Conflicting lock is held in /usr/bin/duckdbm (PID 4242)`
+
+func setLockRetry(t *testing.T, wait, backoff time.Duration) {
+	t.Helper()
+	prevWait, prevBackoff := lockWait, lockBackoff
+	lockWait, lockBackoff = wait, backoff
+	t.Cleanup(func() { lockWait, lockBackoff = prevWait, prevBackoff })
+}
+
+func TestLockHolderPID(t *testing.T) {
+	if pid := lockHolderPID(errors.New(testLockError)); pid != 4242 {
+		t.Errorf("want 4242, got %d", pid)
+	}
+	if pid := lockHolderPID(errors.New(`IO Error: Could not set lock on file "data.db"`)); pid != 0 {
+		t.Errorf("message without a PID: want 0, got %d", pid)
+	}
+}
+
+func TestAttachWithRetry(t *testing.T) {
+	restoreLogger(t)
+	failing := func(n int, err error) (func() error, *int) {
+		calls := 0
+		return func() error {
+			calls++
+			if calls <= n {
+				return err
+			}
+			return nil
+		}, &calls
+	}
+
+	setLockRetry(t, time.Second, time.Millisecond)
+	attach, calls := failing(3, errors.New(testLockError))
+	if err := attachWithRetry(attach); err != nil || *calls != 4 {
+		t.Errorf("lock released after 3 attempts: got %v after %d calls", err, *calls)
+	}
+
+	attach, calls = failing(1, errors.New("IO Error: Cannot open file"))
+	if err := attachWithRetry(attach); err == nil || *calls != 1 {
+		t.Errorf("other errors must not be retried: got %v after %d calls", err, *calls)
+	}
+
+	setLockRetry(t, 0, time.Millisecond)
+	attach, calls = failing(1, errors.New(testLockError))
+	if err := attachWithRetry(attach); !isLockError(err) || *calls != 1 {
+		t.Errorf("lock-wait 0 must fail at once: got %v after %d calls", err, *calls)
+	}
+
+	setLockRetry(t, 30*time.Millisecond, 5*time.Millisecond)
+	attach, calls = failing(1000, errors.New(testLockError))
+	err := attachWithRetry(attach)
+	if connectionExitCode(err) != exitLockTimeout || *calls < 2 {
+		t.Errorf("lock held past lock-wait: got %v after %d calls", err, *calls)
+	}
+
+	prevCtx := rootCtx
+	ctx, cancel := context.WithCancel(context.Background())
+	rootCtx = ctx
+	t.Cleanup(func() { rootCtx = prevCtx })
+	cancel()
+	setLockRetry(t, time.Minute, time.Second)
+	attach, _ = failing(1000, errors.New(testLockError))
+	if err := attachWithRetry(attach); connectionExitCode(err) != exitCancelled {
+		t.Errorf("cancelled while waiting: want exit %d, got %v", exitCancelled, err)
+	}
+}
+
+// TestHoldLockHelper is not a real test: TestConnectDB_WaitsForLock runs the
+// test binary again with DUCKDBM_HOLD_LOCK set so that another process holds
+// the database lock, as DuckDB only enforces it between processes.
+func TestHoldLockHelper(t *testing.T) {
+	path := os.Getenv("DUCKDBM_HOLD_LOCK")
+	if path == "" {
+		t.Skip("helper process only")
+	}
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("locked")
+	hold, _ := time.ParseDuration(os.Getenv("DUCKDBM_HOLD_FOR"))
+	time.Sleep(hold)
+	_ = db.Close()
+}
+
+func TestConnectDB_WaitsForLock(t *testing.T) {
+	restoreLogger(t)
+	path := filepath.Join(t.TempDir(), "locked.db")
+	resetGlobals(t, path, t.TempDir())
+
+	hold := func(d time.Duration) *exec.Cmd {
+		t.Helper()
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHoldLockHelper$")
+		cmd.Env = append(os.Environ(), "DUCKDBM_HOLD_LOCK="+path, "DUCKDBM_HOLD_FOR="+d.String())
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = cmd.Process.Kill(); _ = cmd.Wait() })
+		line, _ := bufio.NewReader(out).ReadString('\n')
+		if line != "locked\n" {
+			t.Fatalf("helper did not take the lock: %q", line)
+		}
+		return cmd
+	}
+
+	cmd := hold(time.Minute)
+	setLockRetry(t, 0, time.Millisecond)
+	_, err := connectDB()
+	if err == nil || connectionExitCode(err) != exitLockTimeout {
+		t.Fatalf("want a lock error, got %v", err)
+	}
+	if pid := lockHolderPID(err); pid != cmd.Process.Pid {
+		t.Errorf("want the helper's PID %d in %q, got %d", cmd.Process.Pid, err, pid)
+	}
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+
+	hold(300 * time.Millisecond)
+	setLockRetry(t, 10*time.Second, 50*time.Millisecond)
+	db, err := connectDB()
+	if err != nil {
+		t.Fatalf("connectDB should wait for the lock to be released: %v", err)
+	}
+	_ = db.Close()
+}
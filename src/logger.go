@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log formats accepted by --log-format.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logger receives operational messages: progress, errors and warnings.
+// Command results (tables, validation and lint reports) are written to stdout
+// instead. setupLogger replaces it once the global flags are parsed.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// runID identifies one invocation of duckdbm across its log lines.
+var runID = newRunID()
+
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "00000000"
+	}
+	return hex.EncodeToString(b)
+}
+
+// setupLogger configures logger from the --log-* flags and tags every line
+// with the command and run ID. It returns the opened log file, if any.
+func setupLogger(level, format, file, command string) (*os.File, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", level)
+	}
+
+	var w io.Writer = os.Stderr
+	var f *os.File
+	if file != "" {
+		var err error
+		if f, err = os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return nil, fmt.Errorf("failed to open log file: %v", err)
+		}
+		w = f
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case logFormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		if f != nil {
+			_ = f.Close()
+		}
+		return nil, fmt.Errorf("unknown log format %q (use text or json)", format)
+	}
+
+	logger = slog.New(handler).With("command", command, "run_id", runID)
+	return f, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// restoreLogger puts the default logger back after a test replaces it.
+func restoreLogger(t *testing.T) {
+	t.Helper()
+	prev := logger
+	t.Cleanup(func() { logger = prev })
+}
+
+func TestSetupLogger_JSONFileWithFields(t *testing.T) {
+	restoreLogger(t)
+	path := filepath.Join(t.TempDir(), "duckdbm.log")
+
+	f, err := setupLogger("info", logFormatJSON, path, "apply")
+	if err != nil {
+		t.Fatalf("setupLogger: %v", err)
+	}
+	logger.Debug("hidden")
+	logger.Info("Migration applied", "migration", "001_a.sql", "duration_ms", int64(12))
+	_ = f.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one line at info level, got %d:\n%s", len(lines), data)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("log line is not JSON: %v\n%s", err, lines[0])
+	}
+	want := map[string]any{
+		"level":       "INFO",
+		"msg":         "Migration applied",
+		"command":     "apply",
+		"run_id":      runID,
+		"migration":   "001_a.sql",
+		"duration_ms": float64(12),
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("%s: want %v, got %v", k, v, entry[k])
+		}
+	}
+}
+
+func TestSetupLogger_RejectsUnknownValues(t *testing.T) {
+	restoreLogger(t)
+	if _, err := setupLogger("loud", logFormatText, "", "list"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	if _, err := setupLogger("info", "xml", "", "list"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestNewRunID_Unique(t *testing.T) {
+	a, b := newRunID(), newRunID()
+	if len(a) != 16 || a == b {
+		t.Errorf("expected distinct 16-character run IDs, got %q and %q", a, b)
+	}
+}
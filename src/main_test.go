@@ -30,7 +30,7 @@ func setupTestDatabase(t *testing.T, i bool) *sql.DB {
 	}
 
 	if i != false {
-		_, err = db.Exec(migrationsTableSQL)
+		_, err = db.Exec(migrationsTableSQL())
 		if err != nil {
 			t.Fatalf("Failed to create migrations table: %v", err)
 		}
@@ -145,8 +145,7 @@ func TestListAppliedMigrations(t *testing.T) {
 		t.Fatalf("Failed to insert test migration: %v", err)
 	}
 
-	var agrs []string
-	listAppliedMigrations(agrs) // Should display the applied migration in stdout
+	listAppliedMigrations("migrations", 10) // Should display the applied migration in stdout
 }
 
 func TestRollbackLast(t *testing.T) {
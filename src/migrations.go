@@ -2,8 +2,9 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"sort"
@@ -12,70 +13,147 @@ import (
 	"time"
 )
 
-func initialize() {
+// initialize creates the tracking tables that are missing and upgrades the
+// rest, reporting what it created. Running it again changes nothing.
+func initialize() int {
 	db, err := connectDB()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		return
+		logger.Error("Database connection error", "error", err)
+		return connectionExitCode(err)
 	}
 	defer func(db *sql.DB) { _ = db.Close() }(db)
 
-	if _, err = db.Exec(migrationsTableSQL); err != nil {
-		fmt.Printf("Error creating migration table: %v\n", err)
-		return
+	created, err := ensureTrackingTables(db)
+	if err != nil {
+		logger.Error("Error creating tracking tables", "error", err)
+		return exitFailure
+	}
+	if len(created) == 0 {
+		logger.Info("The database is already initialized")
+		return exitOK
+	}
+	for _, table := range created {
+		logger.Info("Created tracking table", "table", table)
+	}
+	logger.Info("The database has been initialized")
+	return exitOK
+}
+
+// checkInitialized is init --check: it exits with exitPending if init would
+// create or upgrade anything, without changing or locking the database.
+func checkInitialized() int {
+	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
+		logger.Info("Database does not exist", "database", dbFile)
+		return exitPending
+	}
+	db, err := connectReadOnlyDB()
+	if err != nil {
+		logger.Error("Failed to connect to the database", "error", err)
+		return connectionExitCode(err)
+	}
+	defer func(db *sql.DB) { _ = db.Close() }(db)
+
+	missing, err := missingTrackingTables(db)
+	if err != nil {
+		logger.Error("Failed to check tracking tables", "error", err)
+		return exitFailure
+	}
+	if len(missing) > 0 {
+		logger.Info("Tracking tables are missing", "tables", strings.Join(missing, ", "))
+		return exitPending
+	}
+	v, err := toolSchemaVersion(db)
+	if err != nil {
+		logger.Error("Failed to check tracking tables", "error", err)
+		return exitFailure
 	}
-	if _, err = db.Exec(syncTableSQL); err != nil {
-		fmt.Printf("Error creating sync table: %v\n", err)
-		return
+	if latest := latestToolSchemaVersion(); v < latest {
+		logger.Info("Tracking tables need an upgrade", "from", v, "to", latest)
+		return exitPending
 	}
-	fmt.Println("The database has been initialized..")
+	logger.Info("The database is initialized", "version", v)
+	return exitOK
 }
 
-func createMigration(name string) {
+// missingTrackingTables returns the tracking tables db does not have, as
+// schema.table names.
+func missingTrackingTables(db *sql.DB) ([]string, error) {
+	var missing []string
+	for _, table := range []string{migrationsTable, syncTable, metaTable, syncStateTable} {
+		ok, err := hasTable(db, trackingSchema, table)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			missing = append(missing, trackingSchema+"."+table)
+		}
+	}
+	return missing, nil
+}
+
+// ensureTrackingTables creates the missing tracking tables and runs pending
+// tool-schema upgrades on db, returning the tables it created. It works on
+// db's own connection, so the caller can keep using it.
+func ensureTrackingTables(db *sql.DB) ([]string, error) {
+	missing, err := missingTrackingTables(db)
+	if err != nil {
+		return nil, err
+	}
+	if err = upgradeToolSchema(db, true); err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		// A table dropped after the upgrades ran is recreated in the latest
+		// layout, which is what the recorded version describes.
+		if _, err = db.ExecContext(rootCtx, migrationsTableSQL()+syncTableSQL()+metaTableSQL()+syncStateTableSQL()); err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+func createMigration(name string) int {
 	if err := os.MkdirAll(migrationsDir, os.ModePerm); err != nil {
-		fmt.Printf("Error creating migrations folder: %v\n", err)
-		return
+		logger.Error("Error creating migrations folder", "error", err)
+		return exitFailure
 	}
 
 	files, err := os.ReadDir(migrationsDir)
 	if err != nil {
-		fmt.Printf("Error reading migrations folder: %v\n", err)
-		return
+		logger.Error("Error reading migrations folder", "error", err)
+		return exitFailure
 	}
 
-	filename := fmt.Sprintf("%03d_%s.sql", len(files)+1, name)
+	filename := fmt.Sprintf("%03d_%s.sql", nextMigrationNumber(files), name)
 	filePath := filepath.Join(migrationsDir, filename)
 
 	if err = os.WriteFile(filePath, []byte("-- MIGRATE\n\n-- ROLLBACK\n"), 0644); err != nil {
-		fmt.Printf("Error creating migration file: %v\n", err)
-		return
+		logger.Error("Error creating migration file", "error", err)
+		return exitFailure
 	}
-	fmt.Printf("Migration created: %s\n", filePath)
+	logger.Info("Migration created", "path", filePath)
+	return exitOK
 }
 
-func applyMigrations() {
+func applyMigrations() int {
 	db, err := connectDB()
-	initialize()
 	if err != nil {
-		fmt.Printf("Failed to connect to the database: %v\n", err)
-		return
+		logger.Error("Failed to connect to the database", "error", err)
+		return connectionExitCode(err)
 	}
 	defer func(db *sql.DB) { _ = db.Close() }(db)
 
-	var tableName string
-	err = db.QueryRow("SELECT name FROM sqlite_master WHERE type='table' AND name='migrations'").Scan(&tableName)
-	if err == sql.ErrNoRows {
-		fmt.Println("Migrations table not initialized. Run 'init' first.")
-		return
-	} else if err != nil {
-		fmt.Printf("Failed to check migrations table: %v\n", err)
-		return
+	if created, err := ensureTrackingTables(db); err != nil {
+		logger.Error("Error creating tracking tables", "error", err)
+		return exitFailure
+	} else if len(created) > 0 {
+		logger.Info("The database has been initialized")
 	}
 
-	rows, err := db.Query("SELECT filename FROM attached_db.migrations")
+	rows, err := db.QueryContext(rootCtx, "SELECT filename FROM "+migrationsTableRef())
 	if err != nil {
-		fmt.Printf("Failed to fetch applied migrations: %v\n", err)
-		return
+		logger.Error("Failed to fetch applied migrations", "error", err)
+		return exitFailure
 	}
 	defer rows.Close()
 
@@ -88,8 +166,8 @@ func applyMigrations() {
 
 	files, err := os.ReadDir(migrationsDir)
 	if err != nil {
-		fmt.Printf("Failed to read migrations directory: %v\n", err)
-		return
+		logger.Error("Failed to read migrations directory", "error", err)
+		return exitFailure
 	}
 	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
 
@@ -100,48 +178,88 @@ func applyMigrations() {
 
 		sqlContent, err := os.ReadFile(filepath.Join(migrationsDir, file.Name()))
 		if err != nil {
-			fmt.Printf("Failed to read file %s: %v\n", file.Name(), err)
-			continue
+			logger.Error("Failed to read file", "migration", file.Name(), "error", err)
+			return exitFailure
 		}
 
 		processed, err := processMacros(string(sqlContent))
 		if err != nil {
-			fmt.Printf("Failed to process macros in file %s: %v\n", file.Name(), err)
-			return
+			logger.Error("Failed to process macros", "migration", file.Name(), "error", err)
+			return exitFailure
+		}
+
+		if squashed := parseSquashedFiles(processed); len(squashed) > 0 {
+			handled, err := reconcileSquashed(db, file.Name(), parseArchiveDir(processed), squashed, applied)
+			if err != nil {
+				logger.Error("Failed to reconcile squashed migration", "migration", file.Name(), "error", err)
+				return exitFailure
+			}
+			if handled {
+				logger.Info("Migration marked as applied", "migration", file.Name(), "replaces", len(squashed))
+				continue
+			}
+		}
+
+		if !allowDestructive {
+			if findings := destructiveFindings(file.Name(), string(sqlContent)); len(findings) > 0 {
+				for _, f := range findings {
+					logger.Error("Destructive change", "migration", f.File, "line", f.Line, "rule", f.Rule, "message", f.Message)
+				}
+				logger.Error("Migration contains destructive changes. Rerun with --allow-destructive to apply it.", "migration", file.Name())
+				return exitFailure
+			}
 		}
 
 		migrationSQL := strings.TrimSpace(strings.Split(processed, "-- ROLLBACK")[0])
 
-		start := time.Now()
-		_, err = db.Exec(migrationSQL)
-		durationMs := time.Since(start).Milliseconds()
+		ctx, cancel, err := fileContext(processed)
 		if err != nil {
-			fmt.Printf("Failed to apply migration %s: %v\n", file.Name(), err)
-			break
+			logger.Error("Failed to apply migration", "migration", file.Name(), "error", err)
+			return exitFailure
 		}
-
-		if _, err = db.Exec("INSERT INTO attached_db.migrations (filename, duration_ms) VALUES (?, ?)", file.Name(), durationMs); err != nil {
-			fmt.Printf("Failed to log migration %s: %v\n", file.Name(), err)
-			break
+		var durationMs int64
+		err = withSettings(db, parseSetDirectives(processed), func(settings string) error {
+			durationMs, err = runInTx(ctx, db, migrationSQL, func(tx *sql.Tx, durationMs int64) error {
+				_, err := tx.ExecContext(ctx, "INSERT INTO "+migrationsTableRef()+" (filename, duration_ms, settings) VALUES (?, ?, ?)", file.Name(), durationMs, settings)
+				return err
+			})
+			return err
+		})
+		reason := cancelReason(ctx)
+		cancel()
+		if reason != "" {
+			logger.Error("Migration "+reason+"; its changes were rolled back", "migration", file.Name(), "duration_ms", durationMs, "status", statusCancelled)
+			return exitCancelled
+		}
+		if err != nil {
+			logger.Error("Failed to apply migration", "migration", file.Name(), "duration_ms", durationMs, "error", err)
+			return exitFailure
 		}
 
-		fmt.Printf("Migration applied: %s (%dms)\n", file.Name(), durationMs)
+		logger.Info("Migration applied", "migration", file.Name(), "duration_ms", durationMs)
 	}
+	return exitOK
 }
 
-func rollbackLast(n int) {
+func rollbackLast(n int) int {
 	db, err := connectDB()
-	initialize()
 	if err != nil {
-		fmt.Printf("Failed to connect to the database: %v\n", err)
-		return
+		logger.Error("Failed to connect to the database", "error", err)
+		return connectionExitCode(err)
 	}
 	defer func(db *sql.DB) { _ = db.Close() }(db)
 
-	rows, err := db.Query("SELECT id, filename FROM attached_db.migrations ORDER BY id DESC LIMIT ?", n)
+	if created, err := ensureTrackingTables(db); err != nil {
+		logger.Error("Error creating tracking tables", "error", err)
+		return exitFailure
+	} else if len(created) > 0 {
+		logger.Info("The database has been initialized")
+	}
+
+	rows, err := db.QueryContext(rootCtx, "SELECT id, filename FROM "+migrationsTableRef()+" ORDER BY id DESC LIMIT ?", n)
 	if err != nil {
-		fmt.Printf("Failed to fetch applied migrations: %v\n", err)
-		return
+		logger.Error("Failed to fetch applied migrations", "error", err)
+		return exitFailure
 	}
 	defer rows.Close()
 
@@ -153,104 +271,352 @@ func rollbackLast(n int) {
 	for rows.Next() {
 		var m migration
 		if err = rows.Scan(&m.ID, &m.Filename); err != nil {
-			fmt.Printf("Failed to read migration row: %v\n", err)
-			continue
+			logger.Error("Failed to read migration row", "error", err)
+			return exitFailure
 		}
 		migrations = append(migrations, m)
 	}
 
 	if len(migrations) == 0 {
-		fmt.Println("No migrations to roll back.")
-		return
+		logger.Info("No migrations to roll back")
+		return exitOK
 	}
 
 	for _, m := range migrations {
 		sqlContent, err := os.ReadFile(filepath.Join(migrationsDir, m.Filename))
+		if os.IsNotExist(err) {
+			sqlContent, err = os.ReadFile(archivedMigrationPath(m.Filename))
+		}
 		if err != nil {
-			fmt.Printf("Failed to read migration file %s: %v\n", m.Filename, err)
-			continue
+			logger.Error("Failed to read migration file", "migration", m.Filename, "error", err)
+			return exitFailure
 		}
 
 		parts := strings.Split(string(sqlContent), "-- ROLLBACK")
 		if len(parts) < 2 {
-			fmt.Printf("No rollback section found in migration %s\n", m.Filename)
-			continue
+			logger.Error("No rollback section found", "migration", m.Filename)
+			return exitFailure
 		}
 
 		rollbackSQL, err := processMacros(strings.TrimSpace(parts[1]))
 		if err != nil {
-			fmt.Printf("Failed to process macros in rollback section of file %s: %v\n", m.Filename, err)
-			continue
+			logger.Error("Failed to process macros in rollback section", "migration", m.Filename, "error", err)
+			return exitFailure
 		}
 
-		if _, err = db.Exec(rollbackSQL); err != nil {
-			fmt.Printf("Failed to rollback migration %s: %v\n", m.Filename, err)
-			break
+		ctx, cancel, err := fileContext(string(sqlContent))
+		if err != nil {
+			logger.Error("Failed to rollback migration", "migration", m.Filename, "error", err)
+			return exitFailure
+		}
+		var durationMs int64
+		err = withSettings(db, parseSetDirectives(string(sqlContent)), func(string) error {
+			durationMs, err = runInTx(ctx, db, rollbackSQL, func(tx *sql.Tx, _ int64) error {
+				_, err := tx.ExecContext(ctx, "DELETE FROM "+migrationsTableRef()+" WHERE id = ?", m.ID)
+				return err
+			})
+			return err
+		})
+		reason := cancelReason(ctx)
+		cancel()
+		if reason != "" {
+			logger.Error("Rollback "+reason+"; the migration is still applied", "migration", m.Filename, "duration_ms", durationMs, "status", statusCancelled)
+			return exitCancelled
 		}
-		if _, err = db.Exec("DELETE FROM attached_db.migrations WHERE id = ?", m.ID); err != nil {
-			fmt.Printf("Failed to remove migration log %s: %v\n", m.Filename, err)
-			break
+		if err != nil {
+			logger.Error("Failed to rollback migration", "migration", m.Filename, "duration_ms", durationMs, "error", err)
+			return exitFailure
 		}
-		fmt.Printf("Rolled back migration: %s\n", m.Filename)
+		logger.Info("Rolled back migration", "migration", m.Filename, "duration_ms", durationMs)
 	}
+	return exitOK
 }
 
-func listAppliedMigrations(args []string) {
-	table := "migrations"
-	limit := 10
+// rollbackTo rolls back every migration applied after target, leaving target
+// as the most recently applied migration.
+func rollbackTo(target string) int {
+	db, err := connectDB()
+	if err != nil {
+		logger.Error("Failed to connect to the database", "error", err)
+		return connectionExitCode(err)
+	}
+	if _, err = ensureTrackingTables(db); err != nil {
+		_ = db.Close()
+		logger.Error("Error creating tracking tables", "error", err)
+		return exitFailure
+	}
+	n, err := countAppliedAfter(db, target)
+	_ = db.Close()
+	if errors.Is(err, sql.ErrNoRows) {
+		logger.Error("Migration is not applied", "migration", target)
+		return exitUsage
+	}
+	if err != nil {
+		logger.Error("Failed to fetch applied migrations", "error", err)
+		return exitFailure
+	}
+	if n == 0 {
+		logger.Info("No migrations to roll back")
+		return exitOK
+	}
+	return rollbackLast(n)
+}
+
+// countAppliedAfter returns how many migrations were applied after target. It
+// returns sql.ErrNoRows if target is not applied.
+func countAppliedAfter(db *sql.DB, target string) (int, error) {
+	var id int64
+	if err := db.QueryRowContext(rootCtx, "SELECT id FROM "+migrationsTableRef()+" WHERE filename = ?", target).Scan(&id); err != nil {
+		return 0, err
+	}
+	var n int
+	err := db.QueryRowContext(rootCtx, "SELECT count(*) FROM "+migrationsTableRef()+" WHERE id > ?", id).Scan(&n)
+	return n, err
+}
+
+// migrationNumber returns the numeric prefix of a migration filename.
+func migrationNumber(name string) (int, bool) {
+	prefix, _, found := strings.Cut(name, "_")
+	if !found {
+		return 0, false
+	}
+	n, err := strconv.Atoi(prefix)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
 
-	if len(args) > 1 {
-		table = args[1]
+// nextMigrationNumber returns the number following the highest numbered
+// migration in files.
+func nextMigrationNumber(files []os.DirEntry) int {
+	next := 1
+	for _, file := range files {
+		if n, ok := migrationNumber(file.Name()); ok && n >= next {
+			next = n + 1
+		}
 	}
-	if len(args) > 2 {
-		n, err := strconv.Atoi(args[2])
+	return next
+}
+
+// applyMigrationFiles runs the MIGRATE section of each file in dir against db
+// without recording anything. It is used to build scratch databases.
+func applyMigrationFiles(db *sql.DB, dir string, files []string) error {
+	for _, filename := range files {
+		sqlContent, err := os.ReadFile(filepath.Join(dir, filename))
 		if err != nil {
-			log.Fatalf("Invalid limit: %v", err)
+			return fmt.Errorf("failed to read file %s: %v", filename, err)
+		}
+		processed, err := processMacros(string(sqlContent))
+		if err != nil {
+			return fmt.Errorf("failed to process macros in file %s: %v", filename, err)
+		}
+		migrationSQL := strings.TrimSpace(strings.Split(processed, "-- ROLLBACK")[0])
+		if _, err = db.ExecContext(rootCtx, migrationSQL); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %v", filename, err)
 		}
-		limit = n
 	}
+	return nil
+}
 
-	db, err := connectDB()
+// listMigrationFiles returns the sorted .sql filenames in dir.
+func listMigrationFiles(dir string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".sql") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func listAppliedMigrations(kind string, limit int) int {
+	table, ok := trackingTableFor(kind)
+	if !ok {
+		logger.Error("Unknown table; use migrations or sync", "table", kind)
+		return exitUsage
+	}
+
+	db, err := connectReadOnlyDB()
 	if err != nil {
-		fmt.Printf("Failed to connect to the database: %v\n", err)
-		return
+		logger.Error("Failed to connect to the database", "error", err)
+		return connectionExitCode(err)
 	}
 	defer func(db *sql.DB) { _ = db.Close() }(db)
 
-	var tableName string
-	q := fmt.Sprintf("SELECT name FROM sqlite_master WHERE type='table' AND name='%s'", table)
-	if err = db.QueryRow(q).Scan(&tableName); err == sql.ErrNoRows {
-		fmt.Printf("'%s' table not initialized. Run 'init' first.\n", table)
-		return
-	} else if err != nil {
-		fmt.Printf("Failed to check migrations table: %v\n", err)
-		return
+	if ok, err := hasTable(db, trackingSchema, table); err != nil {
+		logger.Error("Failed to check migrations table", "error", err)
+		return exitFailure
+	} else if !ok {
+		logger.Error("Table not initialized. Run 'init' first.", "table", table)
+		return exitFailure
 	}
 
-	query := fmt.Sprintf("SELECT id, filename, applied_at, duration_ms FROM %s ORDER BY id DESC LIMIT %d", table, limit)
-	rows, err := db.Query(query)
+	// Tables created before settings were recorded lack the column.
+	settingsCol := "NULL"
+	if ok, err := hasColumn(db, trackingSchema, table, "settings"); err != nil {
+		logger.Error("Failed to check migrations table", "error", err)
+		return exitFailure
+	} else if ok {
+		settingsCol = "settings"
+	}
+	statusCol := "NULL"
+	if ok, err := hasColumn(db, trackingSchema, table, "status"); err != nil {
+		logger.Error("Failed to check migrations table", "error", err)
+		return exitFailure
+	} else if ok {
+		statusCol = "status"
+	}
+	query := fmt.Sprintf("SELECT id, filename, applied_at, duration_ms, %s, %s FROM %s ORDER BY id DESC LIMIT %d", settingsCol, statusCol, trackingName(table), limit)
+	rows, err := db.QueryContext(rootCtx, query)
 	if err != nil {
-		fmt.Printf("Failed to fetch applied migrations: %v\n", err)
-		return
+		logger.Error("Failed to fetch applied migrations", "error", err)
+		return exitFailure
 	}
 	defer rows.Close()
 
-	fmt.Printf("Applied %s:\n", table)
-	fmt.Println("ID\tFilename\t\tApplied At\t\tDuration")
-	fmt.Println("----------------------------------------------------------------")
+	out := outputTable{
+		Title: fmt.Sprintf("Applied %s:", kind),
+		Columns: []outputColumn{
+			{Key: "id", Header: "ID"},
+			{Key: "filename", Header: "Filename"},
+			{Key: "applied_at", Header: "Applied At"},
+			{Key: "duration_ms", Header: "Duration", Suffix: "ms"},
+			{Key: "settings", Header: "Settings"},
+		},
+	}
+	if statusCol != "NULL" {
+		out.Columns = append(out.Columns, outputColumn{Key: "status", Header: "Status"})
+	}
 	for rows.Next() {
 		var id int
 		var filename string
 		var appliedAt time.Time
 		var durationMs sql.NullInt64
-		if err = rows.Scan(&id, &filename, &appliedAt, &durationMs); err != nil {
-			fmt.Printf("Failed to read migration row: %v\n", err)
-			continue
+		var settingsJSON, status sql.NullString
+		if err = rows.Scan(&id, &filename, &appliedAt, &durationMs, &settingsJSON, &status); err != nil {
+			logger.Error("Failed to read migration row", "error", err)
+			return exitFailure
 		}
-		durStr := "-"
+		var duration, settings any
 		if durationMs.Valid {
-			durStr = fmt.Sprintf("%dms", durationMs.Int64)
+			duration = durationMs.Int64
+		}
+		if settingsJSON.Valid {
+			settings = json.RawMessage(settingsJSON.String)
+		}
+		row := []any{id, filename, appliedAt, duration, settings}
+		if statusCol != "NULL" {
+			row = append(row, status.String)
 		}
-		fmt.Printf("%d\t%s\t%s\t%s\n", id, filename, appliedAt.Format("2006-01-02 15:04:05"), durStr)
+		out.Rows = append(out.Rows, row)
+	}
+	if err = out.write(os.Stdout, outputFormat); err != nil {
+		logger.Error("Failed to write output", "error", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// showStatus lists every migration file with whether it has been applied,
+// plus applied migrations whose file is gone. With check set, it returns
+// exitDrift for missing files and exitPending for pending migrations.
+func showStatus(check bool) int {
+	db, err := connectReadOnlyDB()
+	if err != nil {
+		logger.Error("Failed to connect to the database", "error", err)
+		return connectionExitCode(err)
+	}
+	defer func(db *sql.DB) { _ = db.Close() }(db)
+
+	if ok, err := hasTable(db, trackingSchema, migrationsTable); err != nil {
+		logger.Error("Failed to check migrations table", "error", err)
+		return exitFailure
+	} else if !ok {
+		logger.Error("Table not initialized. Run 'init' first.", "table", "migrations")
+		return exitFailure
+	}
+
+	type appliedRow struct {
+		appliedAt  time.Time
+		durationMs sql.NullInt64
+	}
+	applied := make(map[string]appliedRow)
+	rows, err := db.QueryContext(rootCtx, "SELECT filename, applied_at, duration_ms FROM "+migrationsTableRef()+" ORDER BY id")
+	if err != nil {
+		logger.Error("Failed to fetch applied migrations", "error", err)
+		return exitFailure
+	}
+	var appliedOrder []string
+	for rows.Next() {
+		var filename string
+		var r appliedRow
+		if err = rows.Scan(&filename, &r.appliedAt, &r.durationMs); err != nil {
+			logger.Error("Failed to read migration row", "error", err)
+			return exitFailure
+		}
+		applied[filename] = r
+		appliedOrder = append(appliedOrder, filename)
+	}
+	_ = rows.Close()
+
+	files, err := listMigrationFiles(migrationsDir)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error("Failed to read migrations directory", "error", err)
+		return exitFailure
+	}
+
+	out := outputTable{
+		Title: "Migration status:",
+		Columns: []outputColumn{
+			{Key: "filename", Header: "Filename"},
+			{Key: "status", Header: "Status"},
+			{Key: "applied_at", Header: "Applied At"},
+			{Key: "duration_ms", Header: "Duration", Suffix: "ms"},
+		},
+	}
+	onDisk := make(map[string]bool, len(files))
+	pending := 0
+	for _, filename := range files {
+		onDisk[filename] = true
+		r, ok := applied[filename]
+		if !ok {
+			out.Rows = append(out.Rows, []any{filename, "pending", nil, nil})
+			pending++
+			continue
+		}
+		var duration any
+		if r.durationMs.Valid {
+			duration = r.durationMs.Int64
+		}
+		out.Rows = append(out.Rows, []any{filename, "applied", r.appliedAt, duration})
+	}
+	missing := 0
+	for _, filename := range appliedOrder {
+		if !onDisk[filename] {
+			r := applied[filename]
+			out.Rows = append(out.Rows, []any{filename, "missing file", r.appliedAt, nil})
+			missing++
+		}
+	}
+
+	if err = out.write(os.Stdout, outputFormat); err != nil {
+		logger.Error("Failed to write output", "error", err)
+		return exitFailure
+	}
+	if outputFormat == formatTable {
+		fmt.Printf("\n%d applied, %d pending.\n", len(appliedOrder), pending)
+	}
+	switch {
+	case check && missing > 0:
+		return exitDrift
+	case check && pending > 0:
+		return exitPending
 	}
+	return exitOK
 }
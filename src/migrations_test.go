@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
@@ -44,6 +47,62 @@ func TestInitialize_CreatesBothTables(t *testing.T) {
 	}
 }
 
+func TestInitialize_IdempotentWithCheck(t *testing.T) {
+	restoreLogger(t)
+	var logs bytes.Buffer
+	logger = slog.New(slog.NewTextHandler(&logs, nil))
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "check.db"), dir)
+
+	if code := checkInitialized(); code != exitPending {
+		t.Errorf("init --check without a database: want %d, got %d", exitPending, code)
+	}
+	if _, err := os.Stat(dbFile); !os.IsNotExist(err) {
+		t.Errorf("init --check must not create the database: %v", err)
+	}
+
+	if code := initialize(); code != exitOK {
+		t.Fatalf("init: exit %d", code)
+	}
+	if n := strings.Count(logs.String(), "Created tracking table"); n != 4 {
+		t.Errorf("first init should report 4 created tables, got %d in %q", n, logs.String())
+	}
+	if code := checkInitialized(); code != exitOK {
+		t.Errorf("init --check after init: want %d, got %d", exitOK, code)
+	}
+
+	logs.Reset()
+	if code := initialize(); code != exitOK {
+		t.Fatalf("second init: exit %d", code)
+	}
+	if !strings.Contains(logs.String(), "already initialized") || strings.Contains(logs.String(), "Created tracking table") {
+		t.Errorf("second init should create nothing, got %q", logs.String())
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("DROP TABLE " + syncTableRef())
+	_ = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := checkInitialized(); code != exitPending {
+		t.Errorf("init --check with the sync table dropped: want %d, got %d", exitPending, code)
+	}
+	if code := applyMigrations(); code != exitOK {
+		t.Errorf("apply should recreate the missing table: exit %d", code)
+	}
+	if db, err = connectDB(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if ok, err := hasTable(db, trackingSchema, syncTable); err != nil || !ok {
+		t.Errorf("sync table should be back after apply: %v, %v", ok, err)
+	}
+}
+
 func TestApplyMigrations_RecordsDurationMs(t *testing.T) {
 	dir := t.TempDir()
 	resetGlobals(t, "test_duration.db", dir)
@@ -186,7 +245,7 @@ func TestListAppliedMigrations_ShowsDuration(t *testing.T) {
 	db.Close()
 
 	// Smoke test: no panic, no crash
-	listAppliedMigrations([]string{})
+	listAppliedMigrations("migrations", 10)
 }
 
 func TestListAppliedMigrations_SyncTable(t *testing.T) {
@@ -207,7 +266,7 @@ func TestListAppliedMigrations_SyncTable(t *testing.T) {
 	}
 	db.Close()
 
-	listAppliedMigrations([]string{"list", "sync"})
+	listAppliedMigrations("sync", 10)
 }
 
 func TestListAppliedMigrations_RespectsLimit(t *testing.T) {
@@ -232,7 +291,7 @@ func TestListAppliedMigrations_RespectsLimit(t *testing.T) {
 	db.Close()
 
 	// limit=2, should not panic
-	listAppliedMigrations([]string{"list", "migrations", "2"})
+	listAppliedMigrations("migrations", 2)
 }
 
 func TestCreateMigration_Sequential(t *testing.T) {
@@ -280,3 +339,48 @@ func TestCreateMigration_FileContents(t *testing.T) {
 		t.Error("missing -- ROLLBACK section")
 	}
 }
+
+func TestRollbackTo(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_rollback_to.db", dir)
+	initialize()
+
+	for i, name := range []string{"a", "b", "c"} {
+		content := fmt.Sprintf("-- MIGRATE\nCREATE TABLE %s (id INTEGER);\n-- ROLLBACK\nDROP TABLE %s;", name, name)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%03d_%s.sql", i+1, name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if code := applyMigrations(); code != exitOK {
+		t.Fatalf("apply: want %d, got %d", exitOK, code)
+	}
+
+	if code := rollbackTo("009_missing.sql"); code != exitUsage {
+		t.Errorf("rollback to an unapplied migration: want %d, got %d", exitUsage, code)
+	}
+	if code := rollbackTo("001_a.sql"); code != exitOK {
+		t.Fatalf("rollback --to: want %d, got %d", exitOK, code)
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var applied []string
+	rows, err := db.Query("SELECT filename FROM attached_db.migrations ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		applied = append(applied, name)
+	}
+	if len(applied) != 1 || applied[0] != "001_a.sql" {
+		t.Errorf("want only 001_a.sql applied, got %v", applied)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+// Output formats accepted by --format.
+const (
+	formatTable    = "table"
+	formatJSON     = "json"
+	formatCSV      = "csv"
+	formatMarkdown = "markdown"
+)
+
+// outputFormat is the format selected with --format for the current command.
+var outputFormat = formatTable
+
+// checkOutputFormat returns an error for an unknown --format value.
+func checkOutputFormat(format string) error {
+	switch format {
+	case formatTable, formatJSON, formatCSV, formatMarkdown:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q (use table, json, csv or markdown)", format)
+}
+
+// outputColumn describes a result column. Key is used in JSON and CSV
+// headers, Header in table and markdown output. Suffix is appended to
+// non-empty values in text formats only, e.g. "ms".
+type outputColumn struct {
+	Key    string
+	Header string
+	Suffix string
+}
+
+// outputTable is a command result that can be rendered in every format.
+// Cells hold native values so JSON keeps numbers and nulls; nil cells are
+// shown as "-" in text formats.
+type outputTable struct {
+	Title   string
+	Columns []outputColumn
+	Rows    [][]any
+}
+
+// write renders t in the given format. The title is only printed in the
+// table format, so machine-readable output contains nothing but data.
+func (t outputTable) write(w io.Writer, format string) error {
+	switch format {
+	case formatJSON:
+		records := make([]map[string]any, 0, len(t.Rows))
+		for _, row := range t.Rows {
+			record := make(map[string]any, len(t.Columns))
+			for i, col := range t.Columns {
+				record[col.Key] = row[i]
+			}
+			records = append(records, record)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		header := make([]string, len(t.Columns))
+		for i, col := range t.Columns {
+			header[i] = col.Key
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		for _, row := range t.Rows {
+			record := make([]string, len(row))
+			for i, v := range row {
+				record[i] = formatCell(v, "", "")
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case formatMarkdown:
+		header := make([]string, len(t.Columns))
+		rule := make([]string, len(t.Columns))
+		for i, col := range t.Columns {
+			header[i] = col.Header
+			rule[i] = "---"
+		}
+		if _, err := fmt.Fprintf(w, "| %s |\n| %s |\n", strings.Join(header, " | "), strings.Join(rule, " | ")); err != nil {
+			return err
+		}
+		for _, row := range t.Rows {
+			cells := make([]string, len(row))
+			for i, v := range row {
+				cells[i] = strings.ReplaceAll(formatCell(v, t.Columns[i].Suffix, "-"), "|", `\|`)
+			}
+			if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | ")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if t.Title != "" {
+		if _, err := fmt.Fprintln(w, t.Title); err != nil {
+			return err
+		}
+	}
+	header := make([]string, len(t.Columns))
+	widths := make([]int, len(t.Columns))
+	for i, col := range t.Columns {
+		header[i] = col.Header
+		widths[i] = utf8.RuneCountInString(col.Header)
+	}
+	rows := make([][]string, len(t.Rows))
+	for r, row := range t.Rows {
+		rows[r] = make([]string, len(row))
+		for i, v := range row {
+			rows[r][i] = formatCell(v, t.Columns[i].Suffix, "-")
+			widths[i] = max(widths[i], utf8.RuneCountInString(rows[r][i]))
+		}
+	}
+	// The rule has a cell per column, so it stays in the tabwriter's column
+	// block and the header lines up with the rows.
+	rule := make([]string, len(widths))
+	for i, n := range widths {
+		rule[i] = strings.Repeat("-", n)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(tw, strings.Join(header, "\t"))
+	_, _ = fmt.Fprintln(tw, strings.Join(rule, "\t"))
+	for _, cells := range rows {
+		_, _ = fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// formatCell renders a cell for text formats.
+func formatCell(v any, suffix, null string) string {
+	switch val := v.(type) {
+	case nil:
+		return null
+	case time.Time:
+		return val.Format("2006-01-02 15:04:05")
+	case json.RawMessage:
+		return string(val)
+	case string:
+		if val == "" {
+			return null
+		}
+		return val + suffix
+	}
+	return fmt.Sprint(v) + suffix
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	prev := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	defer func() { os.Stdout = prev }()
+	fn()
+	_ = w.Close()
+	return string(<-done)
+}
+
+// setOutputFormat selects format for the duration of the test.
+func setOutputFormat(t *testing.T, format string) {
+	t.Helper()
+	prev := outputFormat
+	outputFormat = format
+	t.Cleanup(func() { outputFormat = prev })
+}
+
+func sampleOutputTable() outputTable {
+	return outputTable{
+		Title: "Applied migrations:",
+		Columns: []outputColumn{
+			{Key: "id", Header: "ID"},
+			{Key: "filename", Header: "Filename"},
+			{Key: "applied_at", Header: "Applied At"},
+			{Key: "duration_ms", Header: "Duration", Suffix: "ms"},
+		},
+		Rows: [][]any{
+			{1, "001_a|b.sql", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), int64(42)},
+			{2, "002_c.sql", time.Date(2024, 5, 2, 8, 30, 0, 0, time.UTC), nil},
+		},
+	}
+}
+
+func TestOutputTable_Formats(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{formatTable, []string{"Applied migrations:", "ID", "001_a|b.sql", "2024-05-01 12:00:00", "42ms", "-"}},
+		{formatCSV, []string{"id,filename,applied_at,duration_ms\n", "1,001_a|b.sql,2024-05-01 12:00:00,42\n", "2,002_c.sql,2024-05-02 08:30:00,\n"}},
+		{formatMarkdown, []string{"| ID | Filename | Applied At | Duration |\n| --- | --- | --- | --- |\n", `| 1 | 001_a\|b.sql | 2024-05-01 12:00:00 | 42ms |`, "| 2 | 002_c.sql | 2024-05-02 08:30:00 | - |"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := sampleOutputTable().write(&buf, tt.format); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("expected %q in output:\n%s", want, buf.String())
+				}
+			}
+			if tt.format != formatTable && strings.Contains(buf.String(), "Applied migrations:") {
+				t.Errorf("title must only appear in table output:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+func TestOutputTable_TableAligned(t *testing.T) {
+	var buf bytes.Buffer
+	if err := sampleOutputTable().write(&buf, formatTable); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	want := []string{
+		"Applied migrations:",
+		"ID   Filename      Applied At            Duration",
+		"--   -----------   -------------------   --------",
+		"1    001_a|b.sql   2024-05-01 12:00:00   42ms",
+		"2    002_c.sql     2024-05-02 08:30:00   -",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("table output:\n%s\nwant:\n%s", buf.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestOutputTable_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := sampleOutputTable().write(&buf, formatJSON); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	var records []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0]["duration_ms"] != float64(42) || records[1]["duration_ms"] != nil {
+		t.Errorf("duration_ms must be a number or null, got %v and %v", records[0]["duration_ms"], records[1]["duration_ms"])
+	}
+	if records[0]["applied_at"] != "2024-05-01T12:00:00Z" {
+		t.Errorf("applied_at must be RFC 3339, got %v", records[0]["applied_at"])
+	}
+
+	buf.Reset()
+	if err := (outputTable{}).write(&buf, formatJSON); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("empty result must be an empty array, got %q", buf.String())
+	}
+}
+
+func TestCheckOutputFormat(t *testing.T) {
+	for _, format := range []string{formatTable, formatJSON, formatCSV, formatMarkdown} {
+		if err := checkOutputFormat(format); err != nil {
+			t.Errorf("checkOutputFormat(%q): %v", format, err)
+		}
+	}
+	if err := checkOutputFormat("yaml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestShowStatus_JSON(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_status.db", dir)
+	setOutputFormat(t, formatJSON)
+
+	for name, content := range map[string]string{
+		"001_a.sql": "-- MIGRATE\nCREATE TABLE a (id INTEGER);\n-- ROLLBACK\nDROP TABLE a;",
+		"002_b.sql": "-- MIGRATE\nCREATE TABLE b (id INTEGER);\n-- ROLLBACK\nDROP TABLE b;",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	initialize()
+	captureStdout(t, func() { applyMigrations() })
+	if err := os.WriteFile(filepath.Join(dir, "003_c.sql"), []byte("-- MIGRATE\nSELECT 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "001_a.sql")); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []map[string]any
+	out := captureStdout(t, func() { showStatus(false) })
+	if err := json.Unmarshal([]byte(out), &records); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, out)
+	}
+	got := map[string]any{}
+	for _, r := range records {
+		got[r["filename"].(string)] = r["status"]
+	}
+	want := map[string]any{"001_a.sql": "missing file", "002_b.sql": "applied", "003_c.sql": "pending"}
+	for name, status := range want {
+		if got[name] != status {
+			t.Errorf("%s: want status %v, got %v", name, status, got[name])
+		}
+	}
+}
@@ -1,23 +1,36 @@
 package main
 
 import (
-	"fmt"
 	"os"
 	"regexp"
 	"strings"
 )
 
-// processMacros replaces macros in the SQL file with environment variable values.
-func processMacros(content string) (string, error) {
-	// Regex to match macros of the form {{ENV_VAR}}
-	re := regexp.MustCompile(`\{\{([A-Z0-9_]+)\}\}`)
+// macroRe matches macros of the form {{ENV_VAR}}.
+var macroRe = regexp.MustCompile(`\{\{([A-Z0-9_]+)\}\}`)
 
-	return re.ReplaceAllStringFunc(content, func(match string) string {
+// processMacros replaces macros in the SQL file with the vars from the
+// configuration file or, failing that, environment variable values.
+// Watermark macros become their default, or NULL; sync replaces them with
+// the stored watermarks first.
+func processMacros(content string) (string, error) {
+	if _, err := watermarkNames(content); err != nil {
+		return "", err
+	}
+	content = expandWatermarks(content, nil)
+	return macroRe.ReplaceAllStringFunc(content, func(match string) string {
 		// Extract the environment variable name from the macro
 		varName := strings.Trim(match, "{}")
-		value := os.Getenv(varName)
+		value, ok := macroVars[varName]
+		if !ok {
+			value = os.Getenv(varName)
+		}
 		if value == "" {
-			fmt.Printf("Warning: Environment variable %s is not set\n", varName)
+			if file := unloadedEnvFile(varName); file != "" {
+				logger.Warn("Environment variable is only set in an env file that was not loaded", "variable", varName, "file", file)
+			} else {
+				logger.Warn("Environment variable is not set", "variable", varName)
+			}
 		}
 		return value
 	}), nil
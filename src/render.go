@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// renderMigration prints a migration or sync file with its macros
+// substituted, exactly as apply or sync would run it.
+func renderMigration(name string) int {
+	path, err := resolveMigrationFile(name)
+	if err != nil {
+		logger.Error("Migration file not found", "migration", name)
+		return exitUsage
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		logger.Error("Failed to read migration file", "path", path, "error", err)
+		return exitFailure
+	}
+	processed, err := processMacros(string(content))
+	if err != nil {
+		logger.Error("Failed to process macros", "path", path, "error", err)
+		return exitFailure
+	}
+	fmt.Print(processed)
+	if !strings.HasSuffix(processed, "\n") {
+		fmt.Println()
+	}
+	return exitOK
+}
+
+// resolveMigrationFile finds name, with or without its .sql extension, in the
+// migrations directory or its sync subdirectory.
+func resolveMigrationFile(name string) (string, error) {
+	candidates := []string{name}
+	if !strings.HasSuffix(name, ".sql") {
+		candidates = append(candidates, name+".sql")
+	}
+	for _, dir := range []string{migrationsDir, syncDirectory()} {
+		for _, c := range candidates {
+			path := filepath.Join(dir, c)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path, nil
+			}
+		}
+	}
+	return "", os.ErrNotExist
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderMigration(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_render.db", dir)
+	t.Setenv("RENDER_SCHEMA", "analytics")
+	if err := os.MkdirAll(filepath.Join(dir, "sync"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "001_a.sql"), []byte("-- MIGRATE\nCREATE SCHEMA {{RENDER_SCHEMA}};"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sync", "refresh.sql"), []byte("SELECT 1;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, func() {
+		if code := renderMigration("001_a.sql"); code != exitOK {
+			t.Errorf("render: want %d, got %d", exitOK, code)
+		}
+	})
+	if out != "-- MIGRATE\nCREATE SCHEMA analytics;\n" {
+		t.Errorf("unexpected rendered migration:\n%s", out)
+	}
+
+	if path, err := resolveMigrationFile("refresh"); err != nil || path != filepath.Join(dir, "sync", "refresh.sql") {
+		t.Errorf("resolve sync file without extension: got %q, %v", path, err)
+	}
+	if code := renderMigration("nosuch"); code != exitUsage {
+		t.Errorf("render of a missing file: want %d, got %d", exitUsage, code)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Report kinds accepted by --report.
+const (
+	reportJUnit = "junit"
+	reportSARIF = "sarif"
+)
+
+// reportFlag collects repeatable --report kind=path flags. Only the kinds
+// listed in allowed are accepted.
+type reportFlag struct {
+	allowed []string
+	paths   map[string]string
+}
+
+func newReportFlag(allowed ...string) *reportFlag {
+	return &reportFlag{allowed: allowed, paths: map[string]string{}}
+}
+
+func (r *reportFlag) String() string {
+	if r == nil {
+		return ""
+	}
+	var parts []string
+	for kind, path := range r.paths {
+		parts = append(parts, kind+"="+path)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (r *reportFlag) Set(value string) error {
+	kind, path, ok := strings.Cut(value, "=")
+	if !ok || path == "" {
+		return fmt.Errorf("expected kind=path, got %q", value)
+	}
+	for _, k := range r.allowed {
+		if k == kind {
+			r.paths[kind] = path
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown report kind %q (use %s)", kind, strings.Join(r.allowed, " or "))
+}
+
+// path returns the file a report of kind should be written to, or "".
+func (r *reportFlag) path(kind string) string {
+	if r == nil {
+		return ""
+	}
+	return r.paths[kind]
+}
+
+// validateReports and lintReports are the --report flags of validate and lint.
+var (
+	validateReports = newReportFlag(reportJUnit)
+	lintReports     = newReportFlag(reportSARIF)
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnitReport writes validation results as JUnit XML: one test suite per
+// check and directory, one test case per file. A file with several errors
+// gets a single failure listing all of them.
+func writeJUnitReport(path string, results []validationResult) error {
+	report := junitTestSuites{Name: "duckdbm validate"}
+	suiteIndex := map[string]int{}
+	caseIndex := map[string]int{}
+
+	for _, r := range results {
+		suiteName := r.Check + " " + r.Dir
+		si, ok := suiteIndex[suiteName]
+		if !ok {
+			si = len(report.Suites)
+			suiteIndex[suiteName] = si
+			report.Suites = append(report.Suites, junitTestSuite{Name: suiteName})
+		}
+		suite := &report.Suites[si]
+
+		ci, ok := caseIndex[suiteName+"\x00"+r.File]
+		if !ok {
+			ci = len(suite.Cases)
+			caseIndex[suiteName+"\x00"+r.File] = ci
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      r.File,
+				ClassName: r.Check + "." + filepath.ToSlash(r.Dir),
+				File:      filepath.ToSlash(filepath.Join(r.Dir, r.File)),
+				Line:      r.Line,
+			})
+		}
+		tc := &suite.Cases[ci]
+
+		switch r.Status {
+		case "error":
+			if tc.Failure == nil {
+				tc.Failure = &junitMessage{Message: r.Message}
+				if tc.Line == 0 {
+					tc.Line = r.Line
+				}
+			}
+			if tc.Failure.Text != "" {
+				tc.Failure.Text += "\n"
+			}
+			tc.Failure.Text += r.Message
+		case "skipped":
+			tc.Skipped = &junitMessage{Message: r.Message}
+		}
+	}
+
+	for i := range report.Suites {
+		suite := &report.Suites[i]
+		for _, tc := range suite.Cases {
+			suite.Tests++
+			if tc.Failure != nil {
+				suite.Failures++
+			} else if tc.Skipped != nil {
+				suite.Skipped++
+			}
+		}
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Skipped += suite.Skipped
+	}
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     sarifText          `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifText struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifText       `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// writeSARIFReport writes lint findings as a SARIF 2.1.0 log for code
+// scanning. Finding files must be paths relative to the repository root.
+func writeSARIFReport(path string, findings []lintFinding) error {
+	driver := sarifDriver{
+		Name:           "duckdbm",
+		InformationURI: "https://github.com/Inxo/duckdbm",
+		Rules:          []sarifRule{},
+	}
+	ruleIndex := map[string]int{}
+	for _, rule := range lintRules {
+		ruleIndex[rule.ID] = len(driver.Rules)
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:                   rule.ID,
+			ShortDescription:     sarifText{rule.Description},
+			DefaultConfiguration: sarifConfiguration{rule.Severity},
+		})
+	}
+
+	run := sarifRun{Tool: sarifTool{Driver: driver}, Results: []sarifResult{}}
+	for _, f := range findings {
+		location := sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(f.File)}}
+		if f.Line > 0 {
+			location.Region = &sarifRegion{StartLine: f.Line}
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    f.Rule,
+			RuleIndex: ruleIndex[f.Rule],
+			Level:     f.Severity,
+			Message:   sarifText{f.Message},
+			Locations: []sarifLocation{{PhysicalLocation: location}},
+		})
+	}
+
+	data, err := json.MarshalIndent(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
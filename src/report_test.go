@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReportFlag_Set(t *testing.T) {
+	r := newReportFlag(reportJUnit)
+	if err := r.Set("junit=out/validate.xml"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if got := r.path(reportJUnit); got != "out/validate.xml" {
+		t.Errorf("want out/validate.xml, got %q", got)
+	}
+	for _, bad := range []string{"junit", "junit=", "sarif=out.sarif"} {
+		if err := r.Set(bad); err == nil {
+			t.Errorf("Set(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestWriteJUnitReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "validate.xml")
+	results := []validationResult{
+		{Check: checkApply, Dir: "migrations", File: "001_a.sql", Status: "ok"},
+		{Check: checkApply, Dir: "migrations", File: "002_b.sql", Status: "error", Message: "statement 1 (line 3): boom", Line: 3},
+		{Check: checkApply, Dir: "migrations", File: "002_b.sql", Status: "error", Message: "statement 2 (line 5): bang", Line: 5},
+		{Check: checkApply, Dir: "migrations", File: "003_c.sql", Status: "skipped", Message: "not checked, depends on 002_b.sql"},
+	}
+	if err := writeJUnitReport(path, results); err != nil {
+		t.Fatalf("writeJUnitReport: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report junitTestSuites
+	if err := xml.Unmarshal(data, &report); err != nil {
+		t.Fatalf("report is not valid XML: %v\n%s", err, data)
+	}
+	if report.Tests != 3 || report.Failures != 1 || report.Skipped != 1 {
+		t.Errorf("want 3 tests, 1 failure, 1 skipped; got %d, %d, %d", report.Tests, report.Failures, report.Skipped)
+	}
+	if len(report.Suites) != 1 || len(report.Suites[0].Cases) != 3 {
+		t.Fatalf("expected one suite with 3 cases:\n%s", data)
+	}
+	failed := report.Suites[0].Cases[1]
+	if failed.File != "migrations/002_b.sql" || failed.Line != 3 || failed.Failure == nil {
+		t.Fatalf("unexpected failing case: %+v", failed)
+	}
+	if !strings.Contains(failed.Failure.Text, "boom") || !strings.Contains(failed.Failure.Text, "bang") {
+		t.Errorf("failure must list every error, got %q", failed.Failure.Text)
+	}
+}
+
+func TestWriteSARIFReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lint.sarif")
+	findings := lintContent("002_drop.sql", "-- MIGRATE\nDROP TABLE users;\n", false, nil)
+	for i := range findings {
+		findings[i].File = filepath.Join("migrations", findings[i].File)
+	}
+	if err := writeSARIFReport(path, findings); err != nil {
+		t.Fatalf("writeSARIFReport: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatalf("report is not valid JSON: %v\n%s", err, data)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("unexpected SARIF log:\n%s", data)
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != len(lintRules) {
+		t.Errorf("expected %d rules, got %d", len(lintRules), len(run.Tool.Driver.Rules))
+	}
+	var dropTable *sarifResult
+	for i := range run.Results {
+		if run.Results[i].RuleID == "drop-table" {
+			dropTable = &run.Results[i]
+		}
+	}
+	if dropTable == nil {
+		t.Fatalf("expected a drop-table result:\n%s", data)
+	}
+	loc := dropTable.Locations[0].PhysicalLocation
+	if loc.ArtifactLocation.URI != "migrations/002_drop.sql" || loc.Region == nil || loc.Region.StartLine != 2 {
+		t.Errorf("unexpected location: %+v", loc)
+	}
+	if dropTable.Level != severityError || run.Tool.Driver.Rules[dropTable.RuleIndex].ID != "drop-table" {
+		t.Errorf("unexpected result: %+v", dropTable)
+	}
+}
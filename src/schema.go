@@ -0,0 +1,342 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// schemaDumpHeader starts every file written by dump-schema.
+const schemaDumpHeader = "-- Generated by duckdbm dump-schema. Do not edit by hand.\n"
+
+// sequenceStartRe matches the START clause DuckDB reports for sequences. Once
+// a database is reopened it holds the current value, not the declared one.
+var sequenceStartRe = regexp.MustCompile(` START \d+`)
+
+// schemaObject is a single catalog entry that can be recreated with DDL.
+type schemaObject struct {
+	Kind    string
+	Schema  string
+	Name    string
+	SQL     string
+	Columns []schemaColumn
+}
+
+// schemaColumn describes a table column; it is only filled in for tables.
+type schemaColumn struct {
+	Name     string
+	Type     string
+	Default  string
+	Nullable bool
+}
+
+// schemaKinds lists object kinds in the order they must be created.
+var schemaKinds = []string{"schema", "type", "sequence", "table", "macro", "table_macro", "view", "index"}
+
+// introspectSchema reads user-defined objects of the given database from the
+// DuckDB catalog. Objects are grouped by kind and, within a kind, returned in
+// creation order so the generated DDL can be replayed as-is.
+func introspectSchema(db *sql.DB, database string) ([]schemaObject, error) {
+	var objects []schemaObject
+
+	queries := []struct {
+		kind  string
+		query string
+	}{
+		{"schema", `SELECT schema_name, schema_name, '' FROM duckdb_schemas()
+			WHERE database_name = ? AND NOT internal ORDER BY oid`},
+		{"type", `SELECT schema_name, type_name, '' FROM duckdb_types()
+			WHERE database_name = ? AND NOT internal ORDER BY type_oid`},
+		{"sequence", `SELECT schema_name, sequence_name, sql FROM duckdb_sequences()
+			WHERE database_name = ? ORDER BY sequence_oid`},
+		{"table", `SELECT schema_name, table_name, sql FROM duckdb_tables()
+			WHERE database_name = ? ORDER BY table_oid`},
+		{"macro", `SELECT schema_name, function_name, '' FROM duckdb_functions()
+			WHERE database_name = ? AND NOT internal AND function_type IN ('macro', 'table_macro')
+			GROUP BY schema_name, function_name ORDER BY min(function_oid)`},
+		{"view", `SELECT schema_name, view_name, sql FROM duckdb_views()
+			WHERE database_name = ? AND NOT internal ORDER BY view_oid`},
+		{"index", `SELECT schema_name, index_name, sql FROM duckdb_indexes()
+			WHERE database_name = ? ORDER BY index_oid`},
+	}
+
+	for _, q := range queries {
+		rows, err := db.QueryContext(rootCtx, q.query, database)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s catalog: %v", q.kind, err)
+		}
+		for rows.Next() {
+			var obj schemaObject
+			var ddl sql.NullString
+			if err = rows.Scan(&obj.Schema, &obj.Name, &ddl); err != nil {
+				_ = rows.Close()
+				return nil, fmt.Errorf("failed to read %s catalog row: %v", q.kind, err)
+			}
+			obj.Kind = q.kind
+			obj.SQL = strings.TrimSpace(ddl.String)
+			if isTrackingObject(obj) {
+				continue
+			}
+			objects = append(objects, obj)
+		}
+		_ = rows.Close()
+	}
+
+	for i := range objects {
+		if objects[i].SQL != "" {
+			continue
+		}
+		ddl, kind, err := buildObjectDDL(db, database, objects[i])
+		if err != nil {
+			return nil, err
+		}
+		objects[i].SQL = ddl
+		objects[i].Kind = kind
+	}
+
+	if err := loadTableColumns(db, database, objects); err != nil {
+		return nil, err
+	}
+
+	return sortSchemaObjects(objects), nil
+}
+
+// loadTableColumns fills in Columns for every table in objects.
+func loadTableColumns(db *sql.DB, database string, objects []schemaObject) error {
+	tables := make(map[string]*schemaObject)
+	for i := range objects {
+		if objects[i].Kind == "table" {
+			tables[objects[i].Schema+"."+objects[i].Name] = &objects[i]
+		}
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	rows, err := db.QueryContext(rootCtx, `SELECT schema_name, table_name, column_name, data_type, column_default, is_nullable
+		FROM duckdb_columns() WHERE database_name = ? ORDER BY schema_name, table_name, column_index`, database)
+	if err != nil {
+		return fmt.Errorf("failed to read column catalog: %v", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var schema, table string
+		var col schemaColumn
+		var def sql.NullString
+		if err = rows.Scan(&schema, &table, &col.Name, &col.Type, &def, &col.Nullable); err != nil {
+			return fmt.Errorf("failed to read column catalog row: %v", err)
+		}
+		col.Default = def.String
+		if obj, ok := tables[schema+"."+table]; ok {
+			obj.Columns = append(obj.Columns, col)
+		}
+	}
+	return rows.Err()
+}
+
+// buildObjectDDL reconstructs DDL for objects whose catalog entry does not
+// carry a sql column (schemas, types and macros).
+func buildObjectDDL(db *sql.DB, database string, obj schemaObject) (string, string, error) {
+	name := qualifiedName(obj.Schema, obj.Name)
+	switch obj.Kind {
+	case "schema":
+		return fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s;", quoteIdent(obj.Name)), obj.Kind, nil
+	case "type":
+		var typ string
+		query := fmt.Sprintf("SELECT typeof(NULL::%s.%s.%s)", quoteIdent(database), quoteIdent(obj.Schema), quoteIdent(obj.Name))
+		if err := db.QueryRowContext(rootCtx, query).Scan(&typ); err != nil {
+			return "", "", fmt.Errorf("failed to resolve type %s: %v", name, err)
+		}
+		return fmt.Sprintf("CREATE TYPE %s AS %s;", name, typ), obj.Kind, nil
+	case "macro":
+		var kind, definition string
+		var params []any
+		err := db.QueryRowContext(rootCtx, `SELECT function_type, parameters, macro_definition FROM duckdb_functions()
+			WHERE database_name = ? AND schema_name = ? AND function_name = ? AND NOT internal
+			AND function_type IN ('macro', 'table_macro') LIMIT 1`, database, obj.Schema, obj.Name).
+			Scan(&kind, &params, &definition)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read macro %s: %v", name, err)
+		}
+		names := make([]string, 0, len(params))
+		for _, p := range params {
+			names = append(names, fmt.Sprint(p))
+		}
+		if kind == "table_macro" {
+			return fmt.Sprintf("CREATE MACRO %s(%s) AS TABLE %s;", name, strings.Join(names, ", "), definition), kind, nil
+		}
+		return fmt.Sprintf("CREATE MACRO %s(%s) AS %s;", name, strings.Join(names, ", "), definition), kind, nil
+	}
+	return "", "", fmt.Errorf("cannot build DDL for %s %s", obj.Kind, name)
+}
+
+// sortSchemaObjects orders objects by kind while keeping the catalog order
+// within each kind.
+func sortSchemaObjects(objects []schemaObject) []schemaObject {
+	sorted := make([]schemaObject, 0, len(objects))
+	for _, kind := range schemaKinds {
+		for _, obj := range objects {
+			if obj.Kind == kind {
+				sorted = append(sorted, obj)
+			}
+		}
+	}
+	return sorted
+}
+
+// isTrackingObject reports whether obj belongs to duckdbm itself. A
+// dedicated tracking schema is skipped as a whole.
+func isTrackingObject(obj schemaObject) bool {
+	if obj.Kind == "schema" {
+		return obj.Name == trackingSchema && trackingSchema != defaultTrackingSchema
+	}
+	if obj.Schema != trackingSchema {
+		return false
+	}
+	switch obj.Kind {
+	case "table":
+		return obj.Name == migrationsTable || obj.Name == syncTable || obj.Name == metaTable || obj.Name == syncStateTable
+	case "sequence":
+		return obj.Name == migrationsSequence || obj.Name == syncSequence
+	}
+	return false
+}
+
+// renderSchemaDDL returns the CREATE statements for objects, one per line.
+func renderSchemaDDL(objects []schemaObject) string {
+	var b strings.Builder
+	for _, obj := range objects {
+		b.WriteString(obj.SQL)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// renderDropDDL returns DROP statements that undo renderSchemaDDL, in reverse
+// creation order. Indexes are dropped together with their tables.
+func renderDropDDL(objects []schemaObject) string {
+	var b strings.Builder
+	for i := len(objects) - 1; i >= 0; i-- {
+		obj := objects[i]
+		name := qualifiedName(obj.Schema, obj.Name)
+		switch obj.Kind {
+		case "schema":
+			fmt.Fprintf(&b, "DROP SCHEMA IF EXISTS %s;\n", quoteIdent(obj.Name))
+		case "type":
+			fmt.Fprintf(&b, "DROP TYPE IF EXISTS %s;\n", name)
+		case "sequence":
+			fmt.Fprintf(&b, "DROP SEQUENCE IF EXISTS %s;\n", name)
+		case "table":
+			fmt.Fprintf(&b, "DROP TABLE IF EXISTS %s;\n", name)
+		case "macro":
+			fmt.Fprintf(&b, "DROP MACRO IF EXISTS %s;\n", name)
+		case "table_macro":
+			fmt.Fprintf(&b, "DROP MACRO TABLE IF EXISTS %s;\n", name)
+		case "view":
+			fmt.Fprintf(&b, "DROP VIEW IF EXISTS %s;\n", name)
+		}
+	}
+	return b.String()
+}
+
+// dumpSchema writes the schema of the attached database to path. With check
+// set, nothing is written and exitDrift is returned if path is stale.
+func dumpSchema(path string, check bool) int {
+	// A check must not create the database or upgrade duckdbm's tables.
+	connect := connectDB
+	if check {
+		connect = connectReadOnlyDB
+	}
+	db, err := connect()
+	if err != nil {
+		logger.Error("Failed to connect to the database", "error", err)
+		return connectionExitCode(err)
+	}
+	defer func(db *sql.DB) { _ = db.Close() }(db)
+
+	objects, err := introspectSchema(db, "attached_db")
+	if err != nil {
+		logger.Error("Failed to read schema", "error", err)
+		return exitFailure
+	}
+	dump := renderSchemaDump(objects)
+
+	if check {
+		current, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			logger.Error("Failed to read schema file", "path", path, "error", err)
+			return exitFailure
+		}
+		if string(current) != dump {
+			logger.Error("Schema file is out of date. Run 'dump-schema' and commit the result.", "path", path)
+			return exitDrift
+		}
+		logger.Info("Schema file is up to date", "path", path)
+		return exitOK
+	}
+
+	if err = os.WriteFile(path, []byte(dump), 0644); err != nil {
+		logger.Error("Error writing schema file", "path", path, "error", err)
+		return exitFailure
+	}
+	logger.Info("Schema written", "path", path, "objects", len(objects))
+	return exitOK
+}
+
+// renderSchemaDump formats objects deterministically: grouped by kind, then
+// sorted by schema and name, so the file only changes when the schema does.
+func renderSchemaDump(objects []schemaObject) string {
+	sorted := make([]schemaObject, len(objects))
+	copy(sorted, objects)
+	rank := make(map[string]int, len(schemaKinds))
+	for i, kind := range schemaKinds {
+		rank[kind] = i
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if rank[a.Kind] != rank[b.Kind] {
+			return rank[a.Kind] < rank[b.Kind]
+		}
+		if a.Schema != b.Schema {
+			return a.Schema < b.Schema
+		}
+		return a.Name < b.Name
+	})
+
+	var b strings.Builder
+	b.WriteString(schemaDumpHeader)
+	prevKind := ""
+	for _, obj := range sorted {
+		if obj.Kind != prevKind {
+			b.WriteString("\n")
+			prevKind = obj.Kind
+		}
+		if obj.Kind == "sequence" {
+			b.WriteString(sequenceStartRe.ReplaceAllString(obj.SQL, ""))
+		} else {
+			b.WriteString(obj.SQL)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// qualifiedName quotes name and prefixes it with schema unless it is main.
+func qualifiedName(schema, name string) string {
+	if schema == "" || schema == "main" {
+		return quoteIdent(name)
+	}
+	return quoteIdent(schema) + "." + quoteIdent(name)
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
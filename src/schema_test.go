@@ -0,0 +1,200 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIntrospectSchema_CoversObjectKinds(t *testing.T) {
+	db, err := openScratchDB()
+	if err != nil {
+		t.Fatalf("openScratchDB: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+CREATE SCHEMA reporting;
+CREATE TYPE mood AS ENUM ('happy', 'sad');
+CREATE SEQUENCE seq_users START 1;
+CREATE TABLE users (id INTEGER DEFAULT nextval('seq_users') PRIMARY KEY, name TEXT, m mood);
+CREATE TABLE reporting.daily (d DATE);
+CREATE INDEX idx_users_name ON users(name);
+CREATE MACRO add_one(a) AS a + 1;
+CREATE MACRO first_n(n) AS TABLE SELECT * FROM range(n);
+CREATE VIEW user_names AS SELECT name FROM users;
+`)
+	if err != nil {
+		t.Fatalf("seed schema: %v", err)
+	}
+
+	objects, err := introspectSchema(db, "attached_db")
+	if err != nil {
+		t.Fatalf("introspectSchema: %v", err)
+	}
+
+	var kinds []string
+	for _, obj := range objects {
+		kinds = append(kinds, obj.Kind+":"+obj.Name)
+	}
+	want := []string{
+		"schema:reporting", "type:mood", "sequence:seq_users", "table:users", "table:daily",
+		"macro:add_one", "table_macro:first_n", "view:user_names", "index:idx_users_name",
+	}
+	if strings.Join(kinds, ",") != strings.Join(want, ",") {
+		t.Errorf("objects:\nwant %v\ngot  %v", want, kinds)
+	}
+}
+
+func TestIntrospectSchema_SkipsTrackingTables(t *testing.T) {
+	db, err := openScratchDB()
+	if err != nil {
+		t.Fatalf("openScratchDB: %v", err)
+	}
+	defer db.Close()
+
+	if _, err = db.Exec(migrationsTableSQL() + syncTableSQL()); err != nil {
+		t.Fatalf("create tracking tables: %v", err)
+	}
+
+	objects, err := introspectSchema(db, "attached_db")
+	if err != nil {
+		t.Fatalf("introspectSchema: %v", err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("expected tracking tables to be skipped, got %v", objects)
+	}
+}
+
+func TestRenderSchemaDDL_ReplaysAndDrops(t *testing.T) {
+	src, err := openScratchDB()
+	if err != nil {
+		t.Fatalf("openScratchDB: %v", err)
+	}
+	defer src.Close()
+
+	_, err = src.Exec(`
+CREATE TYPE mood AS ENUM ('happy', 'sad');
+CREATE TABLE users (id INTEGER, m mood);
+CREATE MACRO add_one(a) AS a + 1;
+CREATE VIEW v AS SELECT add_one(id) AS x FROM users;
+`)
+	if err != nil {
+		t.Fatalf("seed schema: %v", err)
+	}
+	objects, err := introspectSchema(src, "attached_db")
+	if err != nil {
+		t.Fatalf("introspectSchema: %v", err)
+	}
+
+	dst, err := openScratchDB()
+	if err != nil {
+		t.Fatalf("openScratchDB: %v", err)
+	}
+	defer dst.Close()
+
+	if _, err = dst.Exec(renderSchemaDDL(objects)); err != nil {
+		t.Fatalf("replay DDL: %v\n%s", err, renderSchemaDDL(objects))
+	}
+	if _, err = dst.Exec(renderDropDDL(objects)); err != nil {
+		t.Fatalf("drop DDL: %v\n%s", err, renderDropDDL(objects))
+	}
+
+	left, err := introspectSchema(dst, "attached_db")
+	if err != nil {
+		t.Fatalf("introspectSchema after drop: %v", err)
+	}
+	if len(left) != 0 {
+		t.Errorf("expected empty schema after drop, got %v", left)
+	}
+}
+
+func TestQualifiedName(t *testing.T) {
+	if got := qualifiedName("main", "users"); got != `"users"` {
+		t.Errorf("main schema: got %s", got)
+	}
+	if got := qualifiedName("rep", `we"ird`); got != `"rep"."we""ird"` {
+		t.Errorf("quoted: got %s", got)
+	}
+}
+
+func TestRenderSchemaDump_SortsByKindAndName(t *testing.T) {
+	objects := []schemaObject{
+		{Kind: "view", Schema: "main", Name: "b_view", SQL: "CREATE VIEW b_view AS SELECT 1;"},
+		{Kind: "table", Schema: "main", Name: "zeta", SQL: "CREATE TABLE zeta(i INTEGER);"},
+		{Kind: "table", Schema: "main", Name: "alpha", SQL: "CREATE TABLE alpha(i INTEGER);"},
+		{Kind: "sequence", Schema: "main", Name: "seq", SQL: "CREATE SEQUENCE seq;"},
+	}
+	want := schemaDumpHeader +
+		"\nCREATE SEQUENCE seq;\n" +
+		"\nCREATE TABLE alpha(i INTEGER);\nCREATE TABLE zeta(i INTEGER);\n" +
+		"\nCREATE VIEW b_view AS SELECT 1;\n"
+	if got := renderSchemaDump(objects); got != want {
+		t.Errorf("unexpected dump:\nwant:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestDumpSchema_WritesAndChecks(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_dump.db", dir)
+	out := filepath.Join(dir, "schema.sql")
+
+	initialize()
+	db, err := connectDB()
+	if err != nil {
+		t.Fatalf("connectDB: %v", err)
+	}
+	_, err = db.Exec("CREATE SEQUENCE s; CREATE TABLE items (id INTEGER DEFAULT nextval('s')); INSERT INTO items DEFAULT VALUES;")
+	db.Close()
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	dumpSchema(out, false)
+	first, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("schema not written: %v", err)
+	}
+	if !strings.Contains(string(first), "CREATE TABLE items") {
+		t.Errorf("dump misses items table:\n%s", first)
+	}
+	if strings.Contains(string(first), "migrations") {
+		t.Errorf("dump must not include tracking tables:\n%s", first)
+	}
+
+	// Using the sequence must not change the dump.
+	db, err = connectDB()
+	if err != nil {
+		t.Fatalf("connectDB: %v", err)
+	}
+	_, _ = db.Exec("INSERT INTO items DEFAULT VALUES")
+	db.Close()
+
+	if code := dumpSchema(out, true); code != exitOK {
+		t.Errorf("check of a fresh dump: want exit %d, got %d", exitOK, code)
+	}
+	second, _ := os.ReadFile(out)
+	if string(first) != string(second) {
+		t.Errorf("check mode must not rewrite the file")
+	}
+
+	if err = os.WriteFile(out, []byte("-- stale\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := dumpSchema(out, true); code != exitDrift {
+		t.Errorf("check of a stale dump: want exit %d, got %d", exitDrift, code)
+	}
+}
+
+func TestDumpSchema_CheckDoesNotCreateDatabase(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "missing.db"), dir)
+
+	if code := dumpSchema(filepath.Join(dir, "schema.sql"), true); code != exitConnection {
+		t.Errorf("check against a missing database: want exit %d, got %d", exitConnection, code)
+	}
+	if _, err := os.Stat(dbFile); !os.IsNotExist(err) {
+		t.Errorf("check must not create %s: %v", dbFile, err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// trackedSettings are recorded with every migration and sync run, whether or
+// not anything overrides them.
+var trackedSettings = []string{"memory_limit", "threads", "temp_directory", "preserve_insertion_order"}
+
+// setDirectiveRe matches per-file settings such as -- SET memory_limit='4GB'.
+var setDirectiveRe = regexp.MustCompile(`(?im)^[ \t]*--[ \t]*SET[ \t]+([a-z_][a-z0-9_]*)[ \t]*=[ \t]*(.*?)[ \t]*;?[ \t]*$`)
+
+// settingOverride is a -- SET directive. Value is the SQL literal as written.
+type settingOverride struct {
+	Name  string
+	Value string
+}
+
+// parseSetDirectives returns the -- SET directives in a migration or sync
+// file, in order.
+func parseSetDirectives(content string) []settingOverride {
+	var overrides []settingOverride
+	for _, m := range setDirectiveRe.FindAllStringSubmatch(content, -1) {
+		overrides = append(overrides, settingOverride{Name: m[1], Value: m[2]})
+	}
+	return overrides
+}
+
+// withSettings applies overrides on db, runs fn with the settings in effect
+// as a JSON object for the history tables, and then puts the previous values
+// back, so one file's settings do not leak into the next. db must be limited
+// to a single connection.
+func withSettings(db *sql.DB, overrides []settingOverride, fn func(settings string) error) error {
+	previous := make(map[string]string, len(overrides))
+	for _, o := range overrides {
+		if _, seen := previous[o.Name]; seen {
+			continue
+		}
+		v, err := currentSetting(db, o.Name)
+		if err != nil {
+			return err
+		}
+		previous[o.Name] = v
+	}
+
+	var err error
+	for _, o := range overrides {
+		if _, err = db.ExecContext(rootCtx, fmt.Sprintf("SET %s = %s", o.Name, o.Value)); err != nil {
+			err = fmt.Errorf("failed to set %s: %v", o.Name, err)
+			break
+		}
+	}
+
+	if err == nil {
+		var effective string
+		if effective, err = effectiveSettings(db, overrides); err == nil {
+			err = fn(effective)
+		}
+	}
+
+	// Restore even when fn was cancelled.
+	for _, name := range sortedKeys(previous) {
+		if _, rerr := db.ExecContext(context.Background(), fmt.Sprintf("SET %s = %s", name, quoteLiteral(previous[name]))); rerr != nil {
+			logger.Warn("Failed to restore setting", "setting", name, "error", rerr)
+		}
+	}
+	return err
+}
+
+// effectiveSettings returns the tracked, configured and overridden settings
+// as a JSON object.
+func effectiveSettings(db *sql.DB, overrides []settingOverride) (string, error) {
+	names := append([]string{}, trackedSettings...)
+	names = append(names, sortedKeys(duckdbSettings)...)
+	for _, o := range overrides {
+		names = append(names, o.Name)
+	}
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		if _, ok := values[name]; ok {
+			continue
+		}
+		v, err := currentSetting(db, name)
+		if err != nil {
+			return "", err
+		}
+		values[name] = v
+	}
+	b, err := json.Marshal(values)
+	return string(b), err
+}
+
+func currentSetting(db *sql.DB, name string) (string, error) {
+	var v sql.NullString
+	if err := db.QueryRowContext(rootCtx, fmt.Sprintf("SELECT current_setting(%s)::VARCHAR", quoteLiteral(name))).Scan(&v); err != nil {
+		return "", fmt.Errorf("failed to read setting %s: %v", name, err)
+	}
+	return v.String, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSetDirectives(t *testing.T) {
+	content := "-- SET memory_limit='4GB'\n--set threads = 2;\n-- MIGRATE\nSET threads = 8;\n-- SETTINGS are not directives\n"
+	want := []settingOverride{{"memory_limit", "'4GB'"}, {"threads", "2"}}
+	if got := parseSetDirectives(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestApplyMigrations_SetDirectivesAreRestored(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_settings.db", dir)
+	initialize()
+
+	files := map[string]string{
+		"001_big.sql":   "-- SET memory_limit='123MB'\n-- MIGRATE\nCREATE TABLE big (id INTEGER);",
+		"002_small.sql": "-- MIGRATE\nCREATE TABLE small (id INTEGER);",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if code := applyMigrations(); code != exitOK {
+		t.Fatalf("apply: want %d, got %d", exitOK, code)
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	recorded := map[string]map[string]string{}
+	rows, err := db.Query("SELECT filename, settings FROM attached_db.migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, settings string
+		if err = rows.Scan(&name, &settings); err != nil {
+			t.Fatal(err)
+		}
+		var values map[string]string
+		if err = json.Unmarshal([]byte(settings), &values); err != nil {
+			t.Fatalf("%s: invalid settings %q: %v", name, settings, err)
+		}
+		recorded[name] = values
+	}
+
+	if got := recorded["001_big.sql"]["memory_limit"]; got != "117.3 MiB" {
+		t.Errorf("001_big.sql should record its memory_limit, got %q", got)
+	}
+	if got := recorded["002_small.sql"]["memory_limit"]; got == "" || got == recorded["001_big.sql"]["memory_limit"] {
+		t.Errorf("002_small.sql should run with the restored memory_limit, got %q", got)
+	}
+	for _, name := range trackedSettings {
+		if _, ok := recorded["002_small.sql"][name]; !ok {
+			t.Errorf("tracked setting %s not recorded", name)
+		}
+	}
+}
+
+func TestWithSettings_InvalidSetting(t *testing.T) {
+	db, err := openScratchDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ran := false
+	err = withSettings(db, []settingOverride{{"no_such_setting", "1"}}, func(string) error {
+		ran = true
+		return nil
+	})
+	if err == nil || ran {
+		t.Errorf("an unknown setting should fail before running the migration: err=%v ran=%v", err, ran)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// squashHeader marks each original migration replaced by a squashed file.
+	squashHeader = "-- SQUASHES "
+	// archiveHeader records where the originals went, relative to
+	// migrationsDir, so rollback can find them.
+	archiveHeader = "-- ARCHIVE "
+	// archiveDirName is where squash moves originals, inside migrationsDir.
+	archiveDirName = "archive"
+)
+
+// squashMigrations replaces every migration numbered up to upTo with a single
+// baseline file holding the resulting schema. The originals are moved into
+// archiveDir; databases that had already run them are reconciled, the -db
+// one at once and the others by apply.
+func squashMigrations(upTo int, name, archiveDir string) int {
+	files, err := listMigrationFiles(migrationsDir)
+	if err != nil {
+		logger.Error("Failed to read migrations directory", "error", err)
+		return exitFailure
+	}
+
+	var selected []string
+	last := 0
+	for _, filename := range files {
+		n, ok := migrationNumber(filename)
+		if !ok || n > upTo {
+			continue
+		}
+		selected = append(selected, filename)
+		last = n
+	}
+	if len(selected) == 0 {
+		logger.Info("No migrations to squash", "up_to", upTo)
+		return exitOK
+	}
+
+	db, err := openScratchDB()
+	if err != nil {
+		logger.Error("Failed to open scratch database", "error", err)
+		return exitFailure
+	}
+	defer func(db *sql.DB) { _ = db.Close() }(db)
+
+	if err = applyMigrationFiles(db, migrationsDir, selected); err != nil {
+		logger.Error("Failed to build scratch database", "error", err)
+		return exitFailure
+	}
+
+	objects, err := introspectSchema(db, "attached_db")
+	if err != nil {
+		logger.Error("Failed to read resulting schema", "error", err)
+		return exitFailure
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Squashed baseline of %d migrations, generated by duckdbm squash.\n", len(selected))
+	b.WriteString("-- Only the schema is carried over; data written by the originals is not.\n")
+	for _, filename := range selected {
+		b.WriteString(squashHeader + filename + "\n")
+	}
+	b.WriteString(archiveHeader + relativeToMigrations(archiveDir) + "\n")
+	b.WriteString("-- MIGRATE\n")
+	b.WriteString(renderSchemaDDL(objects))
+	b.WriteString("\n-- ROLLBACK\n")
+	b.WriteString(renderDropDDL(objects))
+
+	if err = os.MkdirAll(archiveDir, os.ModePerm); err != nil {
+		logger.Error("Error creating archive folder", "error", err)
+		return exitFailure
+	}
+	for _, filename := range selected {
+		if err = os.Rename(filepath.Join(migrationsDir, filename), filepath.Join(archiveDir, filename)); err != nil {
+			logger.Error("Failed to archive migration", "migration", filename, "error", err)
+			return exitFailure
+		}
+	}
+
+	filePath := filepath.Join(migrationsDir, fmt.Sprintf("%03d_%s.sql", last, name))
+	if err = os.WriteFile(filePath, []byte(b.String()), 0644); err != nil {
+		logger.Error("Error creating squashed migration file", "error", err)
+		return exitFailure
+	}
+	logger.Info("Migrations squashed", "migration", filepath.Base(filePath), "squashed", len(selected), "path", filePath, "archive", archiveDir)
+	reconcileDatabase(filepath.Base(filePath), archiveDir, selected)
+	return exitOK
+}
+
+// reconcileDatabase reconciles the squashed file right away in the database,
+// when it exists, so status does not report the archived originals as
+// missing until the next apply. Other databases are reconciled by apply.
+func reconcileDatabase(filename, archiveDir string, squashed []string) {
+	if _, err := os.Stat(dbFile); err != nil {
+		return
+	}
+	db, err := connectDB()
+	if err != nil {
+		logger.Warn("Database not reconciled; apply will do it", "error", err)
+		return
+	}
+	defer func(db *sql.DB) { _ = db.Close() }(db)
+
+	if ok, err := hasTable(db, trackingSchema, migrationsTable); err != nil || !ok {
+		return
+	}
+	rows, err := db.QueryContext(rootCtx, "SELECT filename FROM "+migrationsTableRef())
+	if err != nil {
+		logger.Warn("Database not reconciled; apply will do it", "error", err)
+		return
+	}
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		_ = rows.Scan(&name)
+		applied[name] = true
+	}
+	_ = rows.Close()
+
+	handled, err := reconcileSquashed(db, filename, archiveDir, squashed, applied)
+	if err != nil {
+		logger.Warn("Database not reconciled", "error", err)
+		return
+	}
+	if handled {
+		logger.Info("Migration marked as applied", "migration", filename, "replaces", len(squashed))
+	}
+}
+
+// parseSquashedFiles returns the original migrations listed in a squashed
+// file's header, or nil for a regular migration.
+func parseSquashedFiles(content string) []string {
+	var names []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, squashHeader) {
+			names = append(names, strings.TrimSpace(strings.TrimPrefix(line, squashHeader)))
+		}
+	}
+	return names
+}
+
+// relativeToMigrations returns dir relative to migrationsDir when possible.
+func relativeToMigrations(dir string) string {
+	abs, err1 := filepath.Abs(dir)
+	base, err2 := filepath.Abs(migrationsDir)
+	if err1 != nil || err2 != nil {
+		return dir
+	}
+	if rel, err := filepath.Rel(base, abs); err == nil {
+		return rel
+	}
+	return abs
+}
+
+// parseArchiveDir returns the archive directory recorded in a squashed file,
+// or the default one for files squashed before it was recorded.
+func parseArchiveDir(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, archiveHeader) {
+			dir := strings.TrimSpace(strings.TrimPrefix(line, archiveHeader))
+			if filepath.IsAbs(dir) {
+				return dir
+			}
+			return filepath.Join(migrationsDir, dir)
+		}
+	}
+	return filepath.Join(migrationsDir, archiveDirName)
+}
+
+// archivedMigrationPath returns where squash archived filename, found through
+// the squashed file that replaced it, or the default archive.
+func archivedMigrationPath(filename string) string {
+	files, _ := listMigrationFiles(migrationsDir)
+	for _, f := range files {
+		content, err := os.ReadFile(filepath.Join(migrationsDir, f))
+		if err != nil {
+			continue
+		}
+		for _, name := range parseSquashedFiles(string(content)) {
+			if name == filename {
+				return filepath.Join(parseArchiveDir(string(content)), filename)
+			}
+		}
+	}
+	return filepath.Join(migrationsDir, archiveDirName, filename)
+}
+
+// reconcileSquashed records a squashed migration as applied when the database
+// already ran every migration it replaces, dropping their individual rows.
+// The squashed file takes the place of the first of them in the history, so
+// migrations applied after them are still rolled back first. It returns true
+// when the file was handled and must not be executed.
+func reconcileSquashed(db *sql.DB, filename, archiveDir string, squashed []string, applied map[string]bool) (bool, error) {
+	count := 0
+	for _, name := range squashed {
+		if applied[name] {
+			count++
+		}
+	}
+	if count == 0 {
+		return false, nil
+	}
+	if count < len(squashed) {
+		return false, fmt.Errorf("database has applied only %d of the %d migrations squashed into %s; roll them back first (rollback reads the originals from %s), then apply runs %s instead",
+			count, len(squashed), filename, archiveDir, filename)
+	}
+
+	table := migrationsTableRef()
+	tx, err := db.BeginTx(rootCtx, nil)
+	if err != nil {
+		return false, err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(squashed)), ", ")
+	names := make([]any, len(squashed))
+	for i, name := range squashed {
+		names[i] = name
+	}
+	var first int64
+	err = tx.QueryRowContext(rootCtx, "SELECT min(id) FROM "+table+" WHERE filename IN ("+placeholders+")", names...).Scan(&first)
+	if err == nil {
+		_, err = tx.ExecContext(rootCtx, "DELETE FROM "+table+" WHERE filename IN ("+placeholders+") AND id <> ?", append(names, first)...)
+	}
+	if err == nil {
+		_, err = tx.ExecContext(rootCtx, "UPDATE "+table+" SET filename = ?, duration_ms = 0, settings = NULL WHERE id = ?", filename, first)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
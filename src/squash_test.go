@@ -0,0 +1,231 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSquashFixtures(t *testing.T, dir string) {
+	t.Helper()
+	files := map[string]string{
+		"001_users.sql":  "-- MIGRATE\nCREATE TABLE users (id INTEGER, name TEXT);\n-- ROLLBACK\nDROP TABLE users;\n",
+		"002_email.sql":  "-- MIGRATE\nALTER TABLE users ADD COLUMN email TEXT;\n-- ROLLBACK\nALTER TABLE users DROP COLUMN email;\n",
+		"003_orders.sql": "-- MIGRATE\nCREATE TABLE orders (id INTEGER);\n-- ROLLBACK\nDROP TABLE orders;\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+}
+
+func TestSquashMigrations_WritesBaselineAndArchives(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_squash.db", dir)
+	writeSquashFixtures(t, dir)
+	archive := filepath.Join(dir, archiveDirName)
+
+	squashMigrations(2, "squashed_baseline", archive)
+
+	data, err := os.ReadFile(filepath.Join(dir, "002_squashed_baseline.sql"))
+	if err != nil {
+		t.Fatalf("squashed file not written: %v", err)
+	}
+	content := string(data)
+	if !strings.Contains(content, "email VARCHAR") {
+		t.Errorf("expected resulting schema with email column, got:\n%s", content)
+	}
+	if got := parseSquashedFiles(content); strings.Join(got, ",") != "001_users.sql,002_email.sql" {
+		t.Errorf("squash header: got %v", got)
+	}
+
+	for _, name := range []string{"001_users.sql", "002_email.sql"} {
+		if _, err = os.Stat(filepath.Join(archive, name)); err != nil {
+			t.Errorf("%s not archived: %v", name, err)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(dir, "003_orders.sql")); err != nil {
+		t.Errorf("003_orders.sql must stay in place: %v", err)
+	}
+}
+
+func TestSquashMigrations_AlreadyAppliedDatabase(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_squash_applied.db", dir)
+	writeSquashFixtures(t, dir)
+
+	initialize()
+	applyMigrations()
+	squashMigrations(2, "squashed_baseline", filepath.Join(dir, archiveDirName))
+	applyMigrations()
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatalf("connectDB: %v", err)
+	}
+	defer db.Close()
+
+	var names []string
+	rows, err := db.Query("SELECT filename FROM attached_db.migrations ORDER BY filename")
+	if err != nil {
+		t.Fatalf("query migrations: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		_ = rows.Scan(&name)
+		names = append(names, name)
+	}
+	if strings.Join(names, ",") != "002_squashed_baseline.sql,003_orders.sql" {
+		t.Errorf("history not rewritten, got %v", names)
+	}
+}
+
+func TestSquashMigrations_ReconcilesDatabase(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_squash_reconcile.db", dir)
+	writeSquashFixtures(t, dir)
+
+	initialize()
+	applyMigrations()
+	squashMigrations(2, "squashed_baseline", filepath.Join(dir, archiveDirName))
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatalf("connectDB: %v", err)
+	}
+	defer db.Close()
+	var names []string
+	rows, err := db.Query("SELECT filename FROM attached_db.migrations ORDER BY id")
+	if err != nil {
+		t.Fatalf("query migrations: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		_ = rows.Scan(&name)
+		names = append(names, name)
+	}
+	if strings.Join(names, ",") != "002_squashed_baseline.sql,003_orders.sql" {
+		t.Errorf("squash should reconcile the database without apply, got %v", names)
+	}
+}
+
+func TestSquashMigrations_RollbackAfterReconcile(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_squash_rollback.db", dir)
+	writeSquashFixtures(t, dir)
+
+	initialize()
+	applyMigrations()
+	squashMigrations(2, "squashed_baseline", filepath.Join(dir, archiveDirName))
+	applyMigrations()
+	if code := rollbackLast(1); code != exitOK {
+		t.Fatalf("rollback: exit code %d", code)
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatalf("connectDB: %v", err)
+	}
+	defer db.Close()
+	var names []string
+	rows, err := db.Query("SELECT filename FROM attached_db.migrations ORDER BY id")
+	if err != nil {
+		t.Fatalf("query migrations: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		_ = rows.Scan(&name)
+		names = append(names, name)
+	}
+	if strings.Join(names, ",") != "002_squashed_baseline.sql" {
+		t.Errorf("rollback 1 should undo 003_orders.sql, left %v", names)
+	}
+	var n int
+	if err = db.QueryRow("SELECT count(*) FROM duckdb_tables() WHERE database_name = 'attached_db' AND table_name = 'users'").Scan(&n); err != nil || n != 1 {
+		t.Errorf("users must survive the rollback: count %d (%v)", n, err)
+	}
+}
+
+func TestRollback_ReadsCustomArchive(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_squash_archive.db", dir)
+	writeSquashFixtures(t, dir)
+
+	initialize()
+	applyMigrations()
+	archive := filepath.Join(t.TempDir(), "old")
+	// Squash without the database, so it is not reconciled and
+	// 003_orders.sql is only in the custom archive.
+	prev := dbFile
+	dbFile = filepath.Join(dir, "elsewhere.db")
+	squashMigrations(3, "squashed_baseline", archive)
+	dbFile = prev
+	if code := rollbackLast(1); code != exitOK {
+		t.Errorf("rollback from %s: exit code %d", archive, code)
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatalf("connectDB: %v", err)
+	}
+	defer db.Close()
+	var n int
+	if err = db.QueryRow("SELECT count(*) FROM attached_db.migrations WHERE filename = '003_orders.sql'").Scan(&n); err != nil || n != 0 {
+		t.Errorf("rollback from %s should undo 003_orders.sql: count %d (%v)", archive, n, err)
+	}
+}
+
+func TestSquashMigrations_FreshDatabase(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_squash_fresh.db", dir)
+	writeSquashFixtures(t, dir)
+
+	squashMigrations(2, "squashed_baseline", filepath.Join(dir, archiveDirName))
+	initialize()
+	applyMigrations()
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatalf("connectDB: %v", err)
+	}
+	defer db.Close()
+
+	var email string
+	err = db.QueryRow("SELECT column_name FROM duckdb_columns() WHERE table_name='users' AND column_name='email'").Scan(&email)
+	if err != nil {
+		t.Errorf("squashed schema not applied: %v", err)
+	}
+}
+
+func TestReconcileSquashed_PartiallyApplied(t *testing.T) {
+	handled, err := reconcileSquashed(nil, "002_base.sql", "archive", []string{"001_a.sql", "002_b.sql"},
+		map[string]bool{"001_a.sql": true})
+	if err == nil || handled {
+		t.Errorf("expected partial history to be rejected, got handled=%v err=%v", handled, err)
+	}
+}
+
+func TestCreateMigration_NumbersAfterSquash(t *testing.T) {
+	dir := t.TempDir()
+	prevDir := migrationsDir
+	migrationsDir = dir
+	t.Cleanup(func() { migrationsDir = prevDir })
+
+	if err := os.WriteFile(filepath.Join(dir, "150_squashed_baseline.sql"), []byte("-- MIGRATE\n"), 0644); err != nil {
+		t.Fatalf("write baseline: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, archiveDirName), 0755); err != nil {
+		t.Fatalf("mkdir archive: %v", err)
+	}
+
+	createMigration("next")
+
+	if _, err := os.Stat(filepath.Join(dir, "151_next.sql")); err != nil {
+		t.Errorf("expected 151_next.sql: %v", err)
+	}
+}
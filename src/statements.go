@@ -0,0 +1,143 @@
+package main
+
+import "strings"
+
+// sqlStatement is a single statement of a script together with the 1-based
+// line it starts on, ignoring leading comments.
+type sqlStatement struct {
+	SQL  string
+	Line int
+}
+
+// splitStatements splits a SQL script on semicolons that are not inside
+// quotes or comments. Empty and comment-only statements are dropped.
+func splitStatements(script string) []string {
+	var statements []string
+	for _, stmt := range splitStatementLines(script, 1) {
+		statements = append(statements, stmt.SQL)
+	}
+	return statements
+}
+
+// Kinds of the spans scanSQL splits a script into.
+const (
+	spanCode    = iota
+	spanString  // '...'
+	spanIdent   // "..."
+	spanComment // -- ... or /* ... */
+)
+
+// scanSQL splits script into consecutive spans of code, string literals,
+// quoted identifiers and comments, and calls visit with the kind and bounds
+// of each. Quoted spans include their quotes, and a doubled quote inside one
+// is an escaped quote that does not end it.
+func scanSQL(script string, visit func(kind, start, end int)) {
+	kind, start, closer := spanCode, 0, ""
+	enter := func(i, next int, nextCloser string) {
+		if i > start {
+			visit(kind, start, i)
+		}
+		kind, start, closer = next, i, nextCloser
+	}
+	for i := 0; i < len(script); {
+		if kind == spanCode {
+			switch rest := script[i:]; {
+			case strings.HasPrefix(rest, "--"):
+				enter(i, spanComment, "\n")
+			case strings.HasPrefix(rest, "/*"):
+				enter(i, spanComment, "*/")
+				i++
+			case rest[0] == '\'':
+				enter(i, spanString, "'")
+			case rest[0] == '"':
+				enter(i, spanIdent, `"`)
+			}
+			i++
+			continue
+		}
+		if !strings.HasPrefix(script[i:], closer) {
+			i++
+			continue
+		}
+		i += len(closer)
+		if kind != spanComment && i < len(script) && script[i] == closer[0] {
+			i++
+			continue
+		}
+		enter(i, spanCode, "")
+	}
+	if len(script) > start {
+		visit(kind, start, len(script))
+	}
+}
+
+// splitStatementLines is splitStatements with line numbers; firstLine is the
+// line number of the first line of script within its file.
+func splitStatementLines(script string, firstLine int) []sqlStatement {
+	var statements []sqlStatement
+	var b strings.Builder
+	line, startLine := firstLine, firstLine
+
+	flush := func() {
+		raw := b.String()
+		b.Reset()
+		stmt := strings.TrimSpace(raw)
+		if stmt == "" || isCommentOnly(stmt) {
+			return
+		}
+		// Report the line of the first line that is not blank or a comment.
+		stmtLine := startLine
+		for _, l := range strings.Split(raw, "\n") {
+			trimmed := strings.TrimSpace(l)
+			if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				break
+			}
+			stmtLine++
+		}
+		statements = append(statements, sqlStatement{SQL: stmt, Line: stmtLine})
+	}
+	write := func(text string) {
+		b.WriteString(text)
+		line += strings.Count(text, "\n")
+	}
+
+	scanSQL(script, func(kind, start, end int) {
+		text := script[start:end]
+		if kind == spanCode {
+			for j := strings.IndexByte(text, ';'); j >= 0; j = strings.IndexByte(text, ';') {
+				write(text[:j])
+				flush()
+				startLine = line
+				text = text[j+1:]
+			}
+		}
+		write(text)
+	})
+	flush()
+	return statements
+}
+
+// isCommentOnly reports whether stmt contains nothing but comments.
+func isCommentOnly(stmt string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
+
+// hasDirective reports whether stmt carries a "-- <directive>" comment line.
+func hasDirective(stmt, directive string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "--") {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(strings.TrimPrefix(line, "--")), directive) {
+			return true
+		}
+	}
+	return false
+}
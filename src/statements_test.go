@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"simple", "SELECT 1; SELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"no trailing semicolon", "SELECT 1;\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"semicolon in string", "SELECT 'a;b'; SELECT 2", []string{"SELECT 'a;b'", "SELECT 2"}},
+		{"semicolon in identifier", `SELECT 1 AS "x;y"`, []string{`SELECT 1 AS "x;y"`}},
+		{"semicolon in line comment", "-- one; two\nSELECT 1;", []string{"-- one; two\nSELECT 1"}},
+		{"semicolon in block comment", "/* a; b */ SELECT 1;", []string{"/* a; b */ SELECT 1"}},
+		{"escaped quote", "SELECT 'it''s; fine'; SELECT 2", []string{"SELECT 'it''s; fine'", "SELECT 2"}},
+		{"escaped identifier quote", `SELECT 1 AS "a""b;c"; SELECT 2`, []string{`SELECT 1 AS "a""b;c"`, "SELECT 2"}},
+		{"comment only", "-- nothing here\n;\n", nil},
+		{"empty", "  \n ", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements(%q):\nwant %q\ngot  %q", tt.input, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSplitStatementLines(t *testing.T) {
+	script := "-- MIGRATE\nCREATE TABLE a (id INTEGER);\n\n-- a comment\nINSERT INTO a\nVALUES (1); SELECT 1;"
+	got := splitStatementLines(script, 1)
+	want := []int{2, 5, 6}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d statements, got %d: %+v", len(want), len(got), got)
+	}
+	for i, stmt := range got {
+		if stmt.Line != want[i] {
+			t.Errorf("statement %d: want line %d, got %d (%q)", i+1, want[i], stmt.Line, stmt.SQL)
+		}
+	}
+}
+
+func TestHasDirective(t *testing.T) {
+	stmt := "-- validate skip\nINSTALL mysql"
+	if !hasDirective(stmt, "VALIDATE SKIP") {
+		t.Error("expected directive to match case-insensitively")
+	}
+	if hasDirective("SELECT '-- VALIDATE SKIP'", "VALIDATE SKIP") {
+		t.Error("directive inside a string must not match")
+	}
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
 
+// transactionDirectiveRe matches -- TRANSACTION, which runs a sync file's
+// statements in one transaction with its run record.
+var transactionDirectiveRe = regexp.MustCompile(`(?im)^[ \t]*--[ \t]*TRANSACTION[ \t]*$`)
+
 func startSpinner(name string) chan struct{} {
 	done := make(chan struct{})
 	go func() {
@@ -30,60 +36,273 @@ func startSpinner(name string) chan struct{} {
 	return done
 }
 
-func syncMigration(migrationName string) {
-	if !isSyncTableInitialized() {
-		fmt.Println("Error: Migrations table is not initialized. Run 'init' first.")
-		return
+// syncSpinner shows a progress spinner while a sync file runs. The daemon
+// turns it off.
+var syncSpinner = true
+
+// syncMigration runs one sync file.
+func syncMigration(migrationName string) int {
+	return syncFiles([]string{migrationName})
+}
+
+// syncFiles runs sync files one after another in the given order. A failure
+// does not stop the rest, but cancellation does. When more than one file ran,
+// or output is machine-readable, it prints a summary with a row per file. It
+// returns the exit code of the first failure.
+func syncFiles(names []string) int {
+	out := outputTable{
+		Title: "Sync summary:",
+		Columns: []outputColumn{
+			{Key: "name", Header: "Name"},
+			{Key: "status", Header: "Status"},
+			{Key: "duration_ms", Header: "Duration", Suffix: "ms"},
+			{Key: "error", Header: "Error"},
+		},
+	}
+	code := exitOK
+	for _, name := range names {
+		if code == exitCancelled {
+			out.Rows = append(out.Rows, []any{name, "skipped", nil, ""})
+			continue
+		}
+		durationMs, c, err := runSync(name)
+		if err != nil {
+			logger.Error("Error syncing", "migration", name, "duration_ms", durationMs, "error", err)
+			status := statusFailed
+			if c == exitCancelled {
+				status = statusCancelled
+			}
+			out.Rows = append(out.Rows, []any{name, status, durationMs, err.Error()})
+			if code == exitOK || c == exitCancelled {
+				code = c
+			}
+			continue
+		}
+		logger.Info("Successfully synced", "migration", name, "duration_ms", durationMs)
+		out.Rows = append(out.Rows, []any{name, statusSuccess, durationMs, ""})
+	}
+
+	if len(names) > 1 || outputFormat != formatTable {
+		if outputFormat != formatTable {
+			out.Title = ""
+		}
+		if werr := out.write(os.Stdout, outputFormat); werr != nil {
+			logger.Error("Failed to write output", "error", werr)
+		}
 	}
+	return code
+}
 
-	migrationFile := filepath.Join(migrationsDir, fmt.Sprintf("%s.sql", migrationName))
-	if _, err := os.Stat(migrationFile); os.IsNotExist(err) {
-		fmt.Printf("Error: Migration file %s not found.\n", migrationFile)
-		return
+// runSync executes the MIGRATE section of a sync file on a connection of its
+// own and records the run. On failure it also returns the exit code for the
+// error.
+func runSync(migrationName string) (int64, int, error) {
+	migrationFile, err := resolveSyncFile(migrationName)
+	if err != nil {
+		return 0, exitUsage, err
 	}
 
+	db, err := connectDB()
+	if err != nil {
+		return 0, connectionExitCode(err), fmt.Errorf("failed to connect to the database: %v", err)
+	}
+	defer func(db *sql.DB) { _ = db.Close() }(db)
+	return runSyncOn(db, migrationName, migrationFile)
+}
+
+// runSyncOn is runSync on an open connection, which the daemon shares
+// between its jobs. Webhooks are notified of the final outcome only, not of
+// attempts that were retried.
+func runSyncOn(db *sql.DB, migrationName, migrationFile string) (int64, int, error) {
+	durationMs, code, err := runSyncAttempts(db, migrationName, migrationFile)
+	status, outcome, errMsg := "success", statusSuccess, ""
+	if err != nil {
+		status, outcome, errMsg = "error", statusFailed, err.Error()
+		if code == exitCancelled {
+			outcome = statusCancelled
+		}
+	}
+	sendWebhook("sync", status, outcome, migrationName, durationMs, errMsg)
+	return durationMs, code, err
+}
+
+// runSyncAttempts runs a sync file, and runs it again after a failure its
+// retry policy allows, waiting longer after each attempt. Every attempt is
+// recorded in the sync table.
+func runSyncAttempts(db *sql.DB, migrationName, migrationFile string) (int64, int, error) {
 	sqlContent, err := os.ReadFile(migrationFile)
 	if err != nil {
-		fmt.Printf("Error reading migration file %s: %v\n", migrationFile, err)
-		return
+		return 0, exitFailure, fmt.Errorf("failed to read %s: %v", migrationFile, err)
+	}
+
+	watermarks, err := watermarkNames(string(sqlContent))
+	if err != nil {
+		return 0, exitFailure, fmt.Errorf("failed to process macros in %s: %v", migrationFile, err)
+	}
+
+	if ok, err := hasTable(db, trackingSchema, syncTable); err != nil {
+		return 0, exitFailure, fmt.Errorf("failed to check the sync table: %v", err)
+	} else if !ok {
+		return 0, exitFailure, fmt.Errorf("sync table is not initialized, run 'init' first")
 	}
 
-	processed, err := processMacros(string(sqlContent))
+	content := string(sqlContent)
+	if len(watermarks) > 0 {
+		state, err := loadSyncState(db, migrationName)
+		if err != nil {
+			return 0, exitFailure, fmt.Errorf("failed to read the watermarks: %v", err)
+		}
+		content = expandWatermarks(content, state)
+	}
+	processed, err := processMacros(content)
+	if err != nil {
+		return 0, exitFailure, fmt.Errorf("failed to process macros in %s: %v", migrationFile, err)
+	}
+	policy, err := syncRetryPolicy(migrationName, processed)
 	if err != nil {
-		fmt.Printf("Failed to process macros in file %s: %v\n", migrationFile, err)
-		return
+		return 0, exitFailure, fmt.Errorf("invalid retry policy in %s: %v", migrationFile, err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		run, code, err := syncAttempt(db, migrationName, processed, watermarks, attempt)
+		// Only failures of the file's own statements are worth retrying.
+		if run.Status != statusFailed || attempt >= policy.Attempts || !policy.retryable(err) {
+			if err == nil && attempt > 1 {
+				logger.Info("Sync succeeded after retrying", "migration", migrationName, "attempts", attempt)
+			}
+			return run.DurationMs, code, err
+		}
+		if stopCtx.Err() != nil {
+			logger.Warn("Shutting down, not retrying", "migration", migrationName, "attempt", attempt)
+			return run.DurationMs, code, err
+		}
+		wait := policy.delay(attempt)
+		logger.Warn("Sync failed, retrying", "migration", migrationName, "attempt", attempt, "max_attempts", policy.Attempts, "retry_in", wait, "error", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-rootCtx.Done():
+			timer.Stop()
+			return run.DurationMs, exitCancelled, fmt.Errorf("sync cancelled while waiting to retry: %v", err)
+		case <-stopCtx.Done():
+			timer.Stop()
+			logger.Warn("Shutting down, not retrying", "migration", migrationName, "attempt", attempt)
+			return run.DurationMs, code, err
+		case <-timer.C:
+		}
 	}
+}
+
+// syncAttempt runs the MIGRATE section of a processed sync file once, in a
+// transaction, and records the attempt. Its TIMEOUT applies to each attempt.
+func syncAttempt(db *sql.DB, migrationName, processed string, watermarks []string, attempt int) (syncRun, int, error) {
+	run := syncRun{Attempt: attempt}
 	sqlStatements := strings.Split(processed, "-- ROLLBACK")[0]
 
-	db, err := connectDB()
+	ctx, cancel, err := fileContext(processed)
 	if err != nil {
-		fmt.Printf("Failed to connect to the database: %v\n", err)
-		return
+		return run, exitFailure, err
 	}
-	defer func(db *sql.DB) { _ = db.Close() }(db)
+	defer cancel()
 
-	done := startSpinner(migrationName)
-	start := time.Now()
-	_, err = db.Exec(sqlStatements)
-	durationMs := time.Since(start).Milliseconds()
-	close(done)
-	time.Sleep(50 * time.Millisecond)
+	if run.ID, err = startSyncRun(db, migrationName, attempt); err != nil {
+		return run, exitFailure, fmt.Errorf("failed to record the sync run: %v", err)
+	}
 
+	// The spinner would corrupt machine-readable output.
+	var done chan struct{}
+	if syncSpinner && outputFormat == formatTable {
+		done = startSpinner(migrationName)
+	}
+	// Outside a transaction a file may write to several attached databases,
+	// which DuckDB does not allow within one.
+	inTx := transactionDirectiveRe.MatchString(processed)
+	runScript := runStatements
+	if inTx {
+		runScript = runStatementsInTx
+	}
+	err = withSettings(db, parseSetDirectives(processed), func(effective string) error {
+		run.Settings = effective
+		var err error
+		run.DurationMs, run.Rows, err = runScript(ctx, db, sqlStatements, func(tx *sql.Tx, durationMs int64, rows []int64) error {
+			if err := saveWatermarks(tx, migrationName, watermarks); err != nil {
+				return err
+			}
+			r := run
+			r.DurationMs, r.Rows, r.Status = durationMs, rows, statusSuccess
+			return finishSyncRun(ctx, tx, r)
+		})
+		return err
+	})
+	if done != nil {
+		close(done)
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	code := exitOK
+	run.Status = statusSuccess
+	if reason := cancelReason(ctx); reason != "" {
+		if inTx {
+			err = fmt.Errorf("sync %s; its changes were rolled back", reason)
+		} else {
+			err = fmt.Errorf("sync %s; the statements that had finished were kept", reason)
+		}
+		run.Status, code = statusCancelled, exitCancelled
+	} else if err != nil {
+		run.Status, code = statusFailed, exitFailure
+	}
 	if err != nil {
-		fmt.Printf("✗ Error syncing %s: %v\n", migrationName, err)
-		return
+		// The run never reached its record; record the outcome on its own.
+		run.Err = err
+		if rerr := finishSyncRun(context.Background(), db, run); rerr != nil {
+			logger.Warn("Failed to record the sync run", "migration", migrationName, "status", run.Status, "error", rerr)
+		}
 	}
+	return run, code, err
+}
 
-	recordSyncMigration(db, migrationName, durationMs)
-	fmt.Printf("✓ Successfully synced: %s (%.3fs)\n", migrationName, float64(durationMs)/1000)
+// syncRun is one run of a sync file as recorded in the sync table.
+type syncRun struct {
+	ID         int64
+	Attempt    int // 1 for the first run, 2 for its first retry, and so on
+	DurationMs int64
+	Settings   string
+	Status     string
+	Err        error
+	Rows       []int64 // rows affected by each statement
 }
 
-func recordSyncMigration(db *sql.DB, migrationName string, durationMs int64) {
-	_, err := db.Exec(
-		`INSERT INTO attached_db.sync (filename, applied_at, duration_ms) VALUES (?, ?, ?)`,
-		migrationName, time.Now().UTC(), durationMs,
-	)
-	if err != nil {
-		fmt.Printf("Error recording synced migration: %v\n", err)
+// startSyncRun records that a sync file started, with the run ID, host and
+// attempt number, and returns the id of its row. The row stays running until finishSyncRun
+// records the outcome, so a killed process leaves it running.
+func startSyncRun(db *sql.DB, migrationName string, attempt int) (int64, error) {
+	host, _ := os.Hostname()
+	var id int64
+	err := db.QueryRowContext(rootCtx,
+		"INSERT INTO "+syncTableRef()+" (filename, applied_at, status, run_id, host, attempt) VALUES (?, ?, ?, ?, ?, ?) RETURNING id",
+		migrationName, time.Now().UTC(), statusRunning, runID, sql.NullString{String: host, Valid: host != ""}, attempt,
+	).Scan(&id)
+	return id, err
+}
+
+// finishSyncRun records how a sync run ended: its status, duration, the
+// DuckDB settings it ran with, the rows each statement affected and, unless
+// it succeeded, the error.
+func finishSyncRun(ctx context.Context, db execer, run syncRun) error {
+	var rows sql.NullString
+	if run.Rows != nil {
+		b, err := json.Marshal(run.Rows)
+		if err != nil {
+			return err
+		}
+		rows = sql.NullString{String: string(b), Valid: true}
+	}
+	var errMsg sql.NullString
+	if run.Err != nil {
+		errMsg = sql.NullString{String: run.Err.Error(), Valid: true}
 	}
+	_, err := db.ExecContext(ctx,
+		"UPDATE "+syncTableRef()+" SET duration_ms = ?, settings = ?, status = ?, finished_at = ?, error = ?, rows_affected = ? WHERE id = ?",
+		run.DurationMs, sql.NullString{String: run.Settings, Valid: run.Settings != ""}, run.Status, time.Now().UTC(), errMsg, rows, run.ID,
+	)
+	return err
 }
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 )
 
-func TestRecordSyncMigration_StoresDuration(t *testing.T) {
+func TestFinishSyncRun_StoresOutcome(t *testing.T) {
 	prev := dbFile
 	t.Cleanup(func() {
 		dbFile = prev
@@ -23,13 +26,20 @@ func TestRecordSyncMigration_StoresDuration(t *testing.T) {
 	}
 	defer db.Close()
 
-	recordSyncMigration(db, "001_import.sql", 1234)
+	id, err := startSyncRun(db, "001_import.sql", 1)
+	if err != nil {
+		t.Fatalf("startSyncRun: %v", err)
+	}
+	run := syncRun{ID: id, DurationMs: 1234, Status: statusFailed, Err: errors.New("boom"), Rows: []int64{3, 0}}
+	if err := finishSyncRun(context.Background(), db, run); err != nil {
+		t.Fatalf("finishSyncRun: %v", err)
+	}
 
-	var filename string
+	var filename, status, errMsg, rows string
 	var durationMs int64
 	err = db.QueryRow(
-		"SELECT filename, duration_ms FROM attached_db.sync WHERE filename = '001_import.sql'",
-	).Scan(&filename, &durationMs)
+		"SELECT filename, duration_ms, status, error, rows_affected FROM attached_db.sync WHERE id = ? AND finished_at IS NOT NULL", id,
+	).Scan(&filename, &durationMs, &status, &errMsg, &rows)
 	if err != nil {
 		t.Fatalf("query sync record: %v", err)
 	}
@@ -39,9 +49,12 @@ func TestRecordSyncMigration_StoresDuration(t *testing.T) {
 	if durationMs != 1234 {
 		t.Errorf("duration_ms: want 1234, got %d", durationMs)
 	}
+	if status != statusFailed || errMsg != "boom" || rows != "[3,0]" {
+		t.Errorf("outcome: got status %q, error %q, rows %q", status, errMsg, rows)
+	}
 }
 
-func TestRecordSyncMigration_StoresTimestamp(t *testing.T) {
+func TestStartSyncRun_RecordsRunning(t *testing.T) {
 	prev := dbFile
 	t.Cleanup(func() {
 		dbFile = prev
@@ -58,19 +71,21 @@ func TestRecordSyncMigration_StoresTimestamp(t *testing.T) {
 	defer db.Close()
 
 	before := time.Now().UTC().Add(-time.Second)
-	recordSyncMigration(db, "ts_test.sql", 0)
+	if _, err := startSyncRun(db, "ts_test.sql", 1); err != nil {
+		t.Fatalf("startSyncRun: %v", err)
+	}
 	after := time.Now().UTC().Add(time.Second)
 
-	var count int
+	var status, id string
 	err = db.QueryRow(
-		"SELECT COUNT(*) FROM attached_db.sync WHERE filename='ts_test.sql' AND applied_at BETWEEN ? AND ?",
+		"SELECT status, run_id FROM attached_db.sync WHERE filename='ts_test.sql' AND applied_at BETWEEN ? AND ? AND finished_at IS NULL",
 		before, after,
-	).Scan(&count)
+	).Scan(&status, &id)
 	if err != nil {
 		t.Fatalf("timestamp query: %v", err)
 	}
-	if count != 1 {
-		t.Errorf("expected 1 row within time range, got %d", count)
+	if status != statusRunning || id != runID {
+		t.Errorf("want a running row with run ID %q, got %q, %q", runID, status, id)
 	}
 }
 
@@ -167,6 +182,63 @@ DROP TABLE section_test;
 	}
 }
 
+func TestSync_WritesToTwoDatabases(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "test_twodbs.db"), dir)
+	initialize()
+	setOutputFormat(t, formatJSON)
+
+	other := filepath.Join(dir, "other.db")
+	writeSyncFiles(t, filepath.Join(dir, "sync"), map[string]string{
+		"copy.sql": "-- MIGRATE\nATTACH '" + other + "' AS other;\n" +
+			"CREATE TABLE other.copied AS SELECT 1 AS id;\n" +
+			"CREATE TABLE attached_db.copied AS SELECT * FROM other.copied;\n" +
+			"DETACH other;",
+	})
+	if _, code, err := runSync("copy"); code != exitOK {
+		t.Fatalf("a sync file writing to two databases: exit %d, %v", code, err)
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var status string
+	if err = db.QueryRow("SELECT status FROM attached_db.sync WHERE filename = 'copy'").Scan(&status); err != nil || status != statusSuccess {
+		t.Errorf("run history: got %q, %v", status, err)
+	}
+}
+
+func TestSync_TransactionDirective(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "test_synctx.db"), dir)
+	initialize()
+	setOutputFormat(t, formatJSON)
+
+	failing := "-- MIGRATE\nCREATE TABLE %s (id INTEGER);\nSELECT error('boom');"
+	writeSyncFiles(t, filepath.Join(dir, "sync"), map[string]string{
+		"plain.sql":  fmt.Sprintf(failing, "plain_kept"),
+		"atomic.sql": "-- TRANSACTION\n" + fmt.Sprintf(failing, "atomic_gone"),
+	})
+	for _, name := range []string{"plain", "atomic"} {
+		if _, code, _ := runSync(name); code != exitFailure {
+			t.Errorf("%s: want %d, got %d", name, exitFailure, code)
+		}
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for table, want := range map[string]bool{"plain_kept": true, "atomic_gone": false} {
+		if ok, err := hasTable(db, "main", table); err != nil || ok != want {
+			t.Errorf("%s exists: want %v, got %v, %v", table, want, ok, err)
+		}
+	}
+}
+
 func TestStartSpinner_StartsAndStops(t *testing.T) {
 	done := startSpinner("test_op")
 	time.Sleep(250 * time.Millisecond)
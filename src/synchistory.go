@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syncHistoryTitle is the title of the trends table.
+const syncHistoryTitle = "Sync trends:"
+
+// showSyncHistory implements sync history. Without a name it prints the
+// trends of every sync file: run counts, failure rate and durations. With a
+// name it prints that file's recent runs, followed in the table format by its
+// trends.
+func showSyncHistory(args []string) int {
+	name, limit := "", 20
+	if len(args) > 0 {
+		name = strings.TrimSuffix(args[0], ".sql")
+	}
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			logger.Error("Invalid limit", "limit", args[1])
+			return exitUsage
+		}
+		limit = n
+	}
+	if len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: duckdbm sync history [name] [limit]")
+		return exitUsage
+	}
+
+	db, err := connectReadOnlyDB()
+	if err != nil {
+		logger.Error("Failed to connect to the database", "error", err)
+		return connectionExitCode(err)
+	}
+	defer func(db *sql.DB) { _ = db.Close() }(db)
+
+	if ok, err := hasTable(db, trackingSchema, syncTable); err != nil {
+		logger.Error("Failed to check the sync table", "error", err)
+		return exitFailure
+	} else if !ok {
+		logger.Error("Table not initialized. Run 'init' first.", "table", syncTable)
+		return exitFailure
+	}
+	// Read-only connections cannot upgrade the table.
+	if ok, err := hasColumn(db, trackingSchema, syncTable, "attempt"); err != nil {
+		logger.Error("Failed to check the sync table", "error", err)
+		return exitFailure
+	} else if !ok {
+		logger.Error("The sync table is from an older duckdbm. Run 'init' to upgrade it.", "table", syncTable)
+		return exitFailure
+	}
+
+	trends, err := syncTrends(db, name)
+	if err != nil {
+		logger.Error("Failed to fetch sync history", "error", err)
+		return exitFailure
+	}
+	if name == "" {
+		if err = trends.write(os.Stdout, outputFormat); err != nil {
+			logger.Error("Failed to write output", "error", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+	if len(trends.Rows) == 0 {
+		logger.Error("No sync runs recorded", "migration", name)
+		return exitFailure
+	}
+
+	runs, err := syncRuns(db, name, limit)
+	if err != nil {
+		logger.Error("Failed to fetch sync history", "error", err)
+		return exitFailure
+	}
+	if err = runs.write(os.Stdout, outputFormat); err != nil {
+		logger.Error("Failed to write output", "error", err)
+		return exitFailure
+	}
+	// Machine-readable output holds a single table.
+	if outputFormat == formatTable || outputFormat == formatMarkdown {
+		fmt.Println()
+		if err = trends.write(os.Stdout, outputFormat); err != nil {
+			logger.Error("Failed to write output", "error", err)
+			return exitFailure
+		}
+	}
+	return exitOK
+}
+
+// syncTrends summarizes the recorded runs of every sync file, or only of
+// name. The failure rate is the share of finished runs that failed; average
+// and maximum durations only count successful runs.
+func syncTrends(db *sql.DB, name string) (outputTable, error) {
+	out := outputTable{
+		Title: syncHistoryTitle,
+		Columns: []outputColumn{
+			{Key: "filename", Header: "Filename"},
+			{Key: "runs", Header: "Runs"},
+			{Key: "success", Header: "Success"},
+			{Key: "failed", Header: "Failed"},
+			{Key: "cancelled", Header: "Cancelled"},
+			{Key: "failure_rate", Header: "Failure Rate", Suffix: "%"},
+			{Key: "avg_duration_ms", Header: "Avg Duration", Suffix: "ms"},
+			{Key: "max_duration_ms", Header: "Max Duration", Suffix: "ms"},
+			{Key: "last_run", Header: "Last Run"},
+			{Key: "last_status", Header: "Last Status"},
+		},
+	}
+	query := fmt.Sprintf(`
+SELECT filename,
+       count(*),
+       count(*) FILTER (WHERE status = '%[2]s'),
+       count(*) FILTER (WHERE status = '%[3]s'),
+       count(*) FILTER (WHERE status = '%[4]s'),
+       avg(duration_ms) FILTER (WHERE status = '%[2]s'),
+       max(duration_ms) FILTER (WHERE status = '%[2]s'),
+       max(applied_at),
+       arg_max(status, id)
+FROM %[1]s
+WHERE $1 = '' OR filename = $1
+GROUP BY filename
+ORDER BY filename`, syncTableRef(), statusSuccess, statusFailed, statusCancelled)
+	rows, err := db.QueryContext(rootCtx, query, name)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var filename, lastStatus string
+		var runs, success, failed, cancelled int64
+		var avgMs sql.NullFloat64
+		var maxMs sql.NullInt64
+		var lastRun time.Time
+		if err = rows.Scan(&filename, &runs, &success, &failed, &cancelled, &avgMs, &maxMs, &lastRun, &lastStatus); err != nil {
+			return out, err
+		}
+		var rate, avg, longest any
+		if finished := success + failed + cancelled; finished > 0 {
+			rate = math.Round(float64(failed)*1000/float64(finished)) / 10
+		}
+		if avgMs.Valid {
+			avg = int64(math.Round(avgMs.Float64))
+		}
+		if maxMs.Valid {
+			longest = maxMs.Int64
+		}
+		out.Rows = append(out.Rows, []any{filename, runs, success, failed, cancelled, rate, avg, longest, lastRun, lastStatus})
+	}
+	return out, rows.Err()
+}
+
+// syncRuns lists the last limit runs of a sync file, newest first.
+func syncRuns(db *sql.DB, name string, limit int) (outputTable, error) {
+	out := outputTable{
+		Title: fmt.Sprintf("Sync runs of %s:", name),
+		Columns: []outputColumn{
+			{Key: "id", Header: "ID"},
+			{Key: "run_id", Header: "Run ID"},
+			{Key: "started_at", Header: "Started At"},
+			{Key: "finished_at", Header: "Finished At"},
+			{Key: "status", Header: "Status"},
+			{Key: "attempt", Header: "Attempt"},
+			{Key: "duration_ms", Header: "Duration", Suffix: "ms"},
+			{Key: "rows_affected", Header: "Rows"},
+			{Key: "host", Header: "Host"},
+			{Key: "error", Header: "Error"},
+		},
+	}
+	rows, err := db.QueryContext(rootCtx,
+		"SELECT id, run_id, applied_at, finished_at, status, attempt, duration_ms, rows_affected, host, error FROM "+syncTableRef()+
+			" WHERE filename = ? ORDER BY id DESC LIMIT ?", name, limit)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var startedAt time.Time
+		var finishedAt sql.NullTime
+		var attempt, durationMs sql.NullInt64
+		var rid, status, rowsAffected, host, errMsg sql.NullString
+		if err = rows.Scan(&id, &rid, &startedAt, &finishedAt, &status, &attempt, &durationMs, &rowsAffected, &host, &errMsg); err != nil {
+			return out, err
+		}
+		var finished, tries, duration, affected any
+		if finishedAt.Valid {
+			finished = finishedAt.Time
+		}
+		if attempt.Valid {
+			tries = attempt.Int64
+		}
+		if durationMs.Valid {
+			duration = durationMs.Int64
+		}
+		if rowsAffected.Valid {
+			affected = json.RawMessage(rowsAffected.String)
+		}
+		out.Rows = append(out.Rows, []any{id, nullString(rid), startedAt, finished, nullString(status), tries, duration, affected, nullString(host), nullString(errMsg)})
+	}
+	return out, rows.Err()
+}
+
+// nullString returns s as a cell: its value, or nil for NULL.
+func nullString(s sql.NullString) any {
+	if s.Valid {
+		return s.String
+	}
+	return nil
+}
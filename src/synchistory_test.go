@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestShowSyncHistory(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "test_synchistory.db"), dir)
+	setOutputFormat(t, formatJSON)
+	initialize()
+	writeSyncFiles(t, filepath.Join(dir, "sync"), map[string]string{
+		"load.sql":   "-- MIGRATE\nCREATE TABLE IF NOT EXISTS loaded (id INTEGER);\nINSERT INTO loaded VALUES (1), (2), (3);",
+		"broken.sql": "-- MIGRATE\nSELECT * FROM no_such_table;",
+	})
+	for _, names := range [][]string{{"load", "broken"}, {"load"}} {
+		captureStdout(t, func() { syncFiles(names) })
+	}
+
+	var trends []map[string]any
+	out := captureStdout(t, func() {
+		if code := showSyncHistory(nil); code != exitOK {
+			t.Errorf("sync history: want exit %d, got %d", exitOK, code)
+		}
+	})
+	if err := json.Unmarshal([]byte(out), &trends); err != nil || len(trends) != 2 {
+		t.Fatalf("want trends for two files, got %q (%v)", out, err)
+	}
+	broken, load := trends[0], trends[1]
+	if broken["filename"] != "broken" || broken["failure_rate"] != 100.0 || broken["avg_duration_ms"] != nil || broken["last_status"] != statusFailed {
+		t.Errorf("trends of broken: %v", broken)
+	}
+	if load["runs"] != 2.0 || load["success"] != 2.0 || load["failure_rate"] != 0.0 {
+		t.Errorf("trends of load: %v", load)
+	}
+
+	var runs []map[string]any
+	out = captureStdout(t, func() { showSyncHistory([]string{"load.sql", "1"}) })
+	if err := json.Unmarshal([]byte(out), &runs); err != nil || len(runs) != 1 {
+		t.Fatalf("want one run of load, got %q (%v)", out, err)
+	}
+	if rows, _ := json.Marshal(runs[0]["rows_affected"]); string(rows) != "[0,3]" || runs[0]["run_id"] != runID || runs[0]["finished_at"] == nil {
+		t.Errorf("run of load: %v", runs[0])
+	}
+
+	out = captureStdout(t, func() { showSyncHistory([]string{"broken"}) })
+	if err := json.Unmarshal([]byte(out), &runs); err != nil || len(runs) != 1 || runs[0]["error"] == nil {
+		t.Errorf("the failed run should carry its error, got %q (%v)", out, err)
+	}
+
+	for _, args := range [][]string{{"load", "x"}, {"load", "1", "2"}} {
+		if code := showSyncHistory(args); code != exitUsage {
+			t.Errorf("%v: want exit %d, got %d", args, exitUsage, code)
+		}
+	}
+	if code := showSyncHistory([]string{"never_run"}); code != exitFailure {
+		t.Errorf("unknown file: want exit %d, got %d", exitFailure, code)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRetryBackoff is the delay before the first retry of a sync file
+// when its policy does not set one.
+const defaultRetryBackoff = 10 * time.Second
+
+// retryDirectiveRe matches -- RETRY 3, optionally followed by backoff=30s.
+var retryDirectiveRe = regexp.MustCompile(`(?im)^[ \t]*--[ \t]*RETRY[ \t]+(.+?)[ \t]*$`)
+
+// retryOnDirectiveRe matches -- RETRY_ON <regexp>, an error worth retrying.
+var retryOnDirectiveRe = regexp.MustCompile(`(?im)^[ \t]*--[ \t]*RETRY_ON[ \t]+(.+?)[ \t]*$`)
+
+// Retry settings from duckdbm.yaml. sync_retries maps a sync file name to a
+// retry policy and overrides its -- RETRY header; retry_on lists the errors
+// worth retrying for files without -- RETRY_ON headers.
+var (
+	syncRetries = map[string]string{}
+	retryOn     []*regexp.Regexp
+)
+
+// retryPolicy says how often a failed sync file runs again. With no
+// patterns, every failure is retried.
+type retryPolicy struct {
+	Attempts int
+	Backoff  time.Duration
+	On       []*regexp.Regexp
+}
+
+// parseRetrySpec parses "3 backoff=30s": at most 3 attempts, the second one
+// 30s after the first, each later one after twice the previous delay.
+func parseRetrySpec(spec string) (retryPolicy, error) {
+	fields := strings.Fields(spec)
+	p := retryPolicy{Backoff: defaultRetryBackoff}
+	if len(fields) == 0 {
+		return p, fmt.Errorf("invalid retry policy %q: want the number of attempts", spec)
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil || n < 1 {
+		return p, fmt.Errorf("invalid number of attempts %q", fields[0])
+	}
+	p.Attempts = n
+	for _, opt := range fields[1:] {
+		key, value, _ := strings.Cut(opt, "=")
+		if key != "backoff" {
+			return p, fmt.Errorf("unknown retry option %q (use backoff=<duration>)", opt)
+		}
+		if p.Backoff, err = time.ParseDuration(value); err != nil || p.Backoff < 0 {
+			return p, fmt.Errorf("invalid backoff %q", value)
+		}
+	}
+	return p, nil
+}
+
+func compileRetryPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// syncRetryPolicy returns the retry policy of a sync file: sync_retries or
+// its -- RETRY header, and its -- RETRY_ON headers or retry_on. A file
+// without a policy runs once.
+func syncRetryPolicy(migrationName, content string) (retryPolicy, error) {
+	spec := syncRetries[migrationName]
+	if spec == "" {
+		m := retryDirectiveRe.FindAllStringSubmatch(content, -1)
+		if len(m) > 1 {
+			return retryPolicy{}, fmt.Errorf("more than one RETRY directive")
+		}
+		if len(m) == 1 {
+			spec = m[0][1]
+		}
+	}
+	p := retryPolicy{Attempts: 1}
+	if spec != "" {
+		var err error
+		if p, err = parseRetrySpec(spec); err != nil {
+			return p, err
+		}
+	}
+	p.On = retryOn
+	if m := retryOnDirectiveRe.FindAllStringSubmatch(content, -1); len(m) > 0 {
+		patterns := make([]string, len(m))
+		for i := range m {
+			patterns[i] = m[i][1]
+		}
+		var err error
+		if p.On, err = compileRetryPatterns(patterns); err != nil {
+			return p, fmt.Errorf("RETRY_ON: %v", err)
+		}
+	}
+	return p, nil
+}
+
+// retryable reports whether err matches one of the policy's patterns.
+func (p retryPolicy) retryable(err error) bool {
+	if len(p.On) == 0 {
+		return true
+	}
+	for _, re := range p.On {
+		if re.MatchString(err.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait after the given failed attempt, counted
+// from 1.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < time.Hour; i++ {
+		d *= 2
+	}
+	return d
+}
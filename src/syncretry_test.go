@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRetrySpec(t *testing.T) {
+	p, err := parseRetrySpec("3 backoff=30s")
+	if err != nil || p.Attempts != 3 || p.Backoff != 30*time.Second {
+		t.Errorf("got %+v, %v", p, err)
+	}
+	if p, err = parseRetrySpec("2"); err != nil || p.Backoff != defaultRetryBackoff {
+		t.Errorf("default backoff: got %+v, %v", p, err)
+	}
+	if d := p.delay(3); d != 4*defaultRetryBackoff {
+		t.Errorf("delay after the third attempt: got %s", d)
+	}
+	for _, bad := range []string{"", "0", "three", "3 backoff=soon", "3 jitter=1s"} {
+		if _, err := parseRetrySpec(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestSyncRetryPolicy(t *testing.T) {
+	prevRetries, prevOn := syncRetries, retryOn
+	t.Cleanup(func() { syncRetries, retryOn = prevRetries, prevOn })
+	syncRetries = map[string]string{"import_events": "5 backoff=1m"}
+	retryOn = []*regexp.Regexp{regexp.MustCompile(`(?i)timed? ?out`)}
+
+	content := "-- RETRY 3 backoff=30s\n-- MIGRATE\nSELECT 1;"
+	p, err := syncRetryPolicy("import_users", content)
+	if err != nil || p.Attempts != 3 || p.Backoff != 30*time.Second {
+		t.Errorf("header: got %+v, %v", p, err)
+	}
+	if !p.retryable(errors.New("IO Error: connection timed out")) || p.retryable(errors.New("Catalog Error: no such table")) {
+		t.Error("retry_on patterns not applied")
+	}
+	if p, _ = syncRetryPolicy("import_events", content); p.Attempts != 5 || p.Backoff != time.Minute {
+		t.Errorf("sync_retries should override the header: got %+v", p)
+	}
+	if p, _ = syncRetryPolicy("adhoc", "-- MIGRATE\nSELECT 1;"); p.Attempts != 1 {
+		t.Errorf("no policy: got %+v", p)
+	}
+
+	p, err = syncRetryPolicy("import_users", content+"\n-- RETRY_ON Unable to connect")
+	if err != nil || !p.retryable(errors.New("IO Error: Unable to connect to MySQL")) || p.retryable(errors.New("timed out")) {
+		t.Errorf("RETRY_ON should replace retry_on: got %+v, %v", p, err)
+	}
+	for _, bad := range []string{"-- RETRY 2\n-- RETRY 3", "-- RETRY x", "-- RETRY 2\n-- RETRY_ON ("} {
+		if _, err := syncRetryPolicy("bad", bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestSync_RetriesAndNotifiesOnce(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "test_retry.db"), dir)
+	setOutputFormat(t, formatJSON)
+	initialize()
+
+	var mu sync.Mutex
+	var notified []webhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhookPayload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		mu.Lock()
+		notified = append(notified, p)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	prevHooks := webhookURLs
+	t.Cleanup(func() { webhookURLs = prevHooks })
+	webhookURLs = []string{srv.URL}
+
+	// Both fail on the first attempt only; the second file's error is not
+	// retryable.
+	writeSyncFiles(t, filepath.Join(dir, "sync"), map[string]string{
+		"flaky.sql": "-- RETRY 3 backoff=10ms\n-- MIGRATE\n" +
+			"SELECT CASE WHEN (SELECT count(*) FROM attached_db.sync WHERE filename = 'flaky' AND status = 'failed') = 0 THEN error('connection timed out') END;",
+		"broken.sql": "-- RETRY 3 backoff=10ms\n-- RETRY_ON timed out\n-- MIGRATE\n" +
+			"SELECT CASE WHEN (SELECT count(*) FROM attached_db.sync WHERE filename = 'broken' AND status = 'failed') = 0 THEN error('syntax is wrong') END;",
+	})
+	captureStdout(t, func() {
+		if code := syncFiles([]string{"flaky", "broken"}); code != exitFailure {
+			t.Errorf("exit code: want %d, got %d", exitFailure, code)
+		}
+	})
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT filename, attempt, status FROM attached_db.sync ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var name, status string
+		var attempt int
+		if err := rows.Scan(&name, &attempt, &status); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s#%d %s", name, attempt, status))
+	}
+	want := []string{"flaky#1 failed", "flaky#2 success", "broken#1 failed"}
+	if len(got) != len(want) {
+		t.Fatalf("runs: want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("runs: want %v, got %v", want, got)
+			break
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(notified) != 2 || notified[0].Name != "flaky" || notified[0].Status != "success" || notified[0].Outcome != statusSuccess ||
+		notified[1].Name != "broken" || notified[1].Status != "error" || notified[1].Outcome != statusFailed {
+		t.Errorf("notifications: got %+v", notified)
+	}
+}
+
+func TestSync_NoRetryAfterShutdown(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "test_retry_stop.db"), dir)
+	setOutputFormat(t, formatJSON)
+	initialize()
+	writeSyncFiles(t, filepath.Join(dir, "sync"), map[string]string{
+		"flaky.sql": "-- RETRY 3 backoff=1h\n-- MIGRATE\nSELECT error('connection timed out');",
+	})
+
+	prevStop := stopCtx
+	t.Cleanup(func() { stopCtx = prevStop })
+	stop, cancel := context.WithCancel(context.Background())
+	stopCtx = stop
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, code, err := runSync("flaky")
+	if code != exitFailure || err == nil {
+		t.Errorf("want the failure of the last attempt, got code %d, err %v", code, err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("shutdown should end the backoff, took %s", d)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// syncGroups maps a group name to sync file names or globs, from sync_groups
+// in duckdbm.yaml. Files can also join groups with a -- GROUP header.
+var syncGroups = map[string][]string{}
+
+// groupDirectiveRe matches -- GROUP nightly, or several groups separated by
+// commas or spaces.
+var groupDirectiveRe = regexp.MustCompile(`(?im)^[ \t]*--[ \t]*GROUP[ \t]+(.+?)[ \t]*$`)
+
+// syncSelection is what the sync command was asked to run.
+type syncSelection struct {
+	All      bool
+	Groups   []string
+	Patterns []string // names or globs
+}
+
+// listSyncFiles returns the names, without .sql, of the files in the sync
+// directory, sorted.
+func listSyncFiles() ([]string, error) {
+	files, err := listMigrationFiles(syncDirectory())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = strings.TrimSuffix(f, ".sql")
+	}
+	return names, nil
+}
+
+// parseGroupDirectives returns the groups a sync file declares.
+func parseGroupDirectives(content string) []string {
+	var groups []string
+	for _, m := range groupDirectiveRe.FindAllStringSubmatch(content, -1) {
+		groups = append(groups, strings.FieldsFunc(m[1], func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })...)
+	}
+	return groups
+}
+
+// syncFileGroups returns the groups of every file in the sync directory,
+// from their -- GROUP headers and from sync_groups.
+func syncFileGroups(names []string) (map[string][]string, error) {
+	groups := map[string][]string{}
+	for _, name := range names {
+		content, err := os.ReadFile(filepath.Join(syncDirectory(), name+".sql"))
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range parseGroupDirectives(string(content)) {
+			groups[g] = append(groups[g], name)
+		}
+	}
+	for _, g := range sortedKeys(syncGroups) {
+		for _, pattern := range syncGroups[g] {
+			matched, err := matchSyncFiles(names, pattern)
+			if err != nil {
+				return nil, fmt.Errorf("sync_groups.%s: %v", g, err)
+			}
+			groups[g] = append(groups[g], matched...)
+		}
+	}
+	return groups, nil
+}
+
+// matchSyncFiles returns the names matching pattern, a file name or glob
+// with or without .sql.
+func matchSyncFiles(names []string, pattern string) ([]string, error) {
+	pattern = strings.TrimSuffix(pattern, ".sql")
+	var matched []string
+	for _, name := range names {
+		ok, err := filepath.Match(pattern, name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		if ok {
+			matched = append(matched, name)
+		}
+	}
+	return matched, nil
+}
+
+// isGlob reports whether s uses glob syntax rather than naming one file.
+func isGlob(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// selectSyncFiles resolves sel to the sync files to run, in name order and
+// without duplicates. Plain names are passed through so that runSync can
+// report a missing file or find one in the legacy location; globs, groups
+// and --all only match files in the sync directory.
+func selectSyncFiles(sel syncSelection) ([]string, error) {
+	names, err := listSyncFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync directory: %v", err)
+	}
+
+	selected := map[string]bool{}
+	if sel.All {
+		for _, name := range names {
+			selected[name] = true
+		}
+	}
+	if len(sel.Groups) > 0 {
+		groups, err := syncFileGroups(names)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range sel.Groups {
+			members, ok := groups[g]
+			if !ok {
+				return nil, fmt.Errorf("no sync files in group %q (groups: %s)", g, strings.Join(sortedKeys(groups), ", "))
+			}
+			for _, name := range members {
+				selected[name] = true
+			}
+		}
+	}
+	for _, p := range sel.Patterns {
+		if !isGlob(p) {
+			selected[strings.TrimSuffix(p, ".sql")] = true
+			continue
+		}
+		matched, err := matchSyncFiles(names, p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("no sync files in %s match %q", syncDirectory(), p)
+		}
+		for _, name := range matched {
+			selected[name] = true
+		}
+	}
+
+	if len(selected) == 0 && sel.All {
+		return nil, fmt.Errorf("no sync files in %s", syncDirectory())
+	}
+	return sortedKeys(selected), nil
+}
+
+// resolveSyncFile returns the path of the sync file called name. Files are
+// looked up in the sync directory and, for projects that predate it, in the
+// migrations directory.
+func resolveSyncFile(name string) (string, error) {
+	path := filepath.Join(syncDirectory(), name+".sql")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	legacy := filepath.Join(migrationsDir, name+".sql")
+	if _, err := os.Stat(legacy); err == nil {
+		logger.Warn("Sync file found in the migrations directory; move it to the sync directory", "migration", name, "sync_dir", syncDirectory())
+		return legacy, nil
+	}
+	return "", fmt.Errorf("sync file %s not found in %s or %s", name+".sql", syncDirectory(), migrationsDir)
+}
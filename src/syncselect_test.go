@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeSyncFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSelectSyncFiles(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_syncselect.db", dir)
+	prevGroups := syncGroups
+	t.Cleanup(func() { syncGroups = prevGroups })
+	syncGroups = map[string][]string{"weekly": {"import_*"}}
+	writeSyncFiles(t, filepath.Join(dir, "sync"), map[string]string{
+		"import_users.sql":  "-- GROUP nightly\n-- MIGRATE\nSELECT 1;",
+		"import_events.sql": "-- GROUP nightly, hourly\n-- MIGRATE\nSELECT 1;",
+		"refresh_stats.sql": "-- GROUP hourly\n-- MIGRATE\nSELECT 1;",
+		"notes.txt":         "",
+	})
+
+	cases := []struct {
+		name string
+		sel  syncSelection
+		want []string
+	}{
+		{"all", syncSelection{All: true}, []string{"import_events", "import_users", "refresh_stats"}},
+		{"group header", syncSelection{Groups: []string{"hourly"}}, []string{"import_events", "refresh_stats"}},
+		{"group from config", syncSelection{Groups: []string{"weekly"}}, []string{"import_events", "import_users"}},
+		{"glob", syncSelection{Patterns: []string{"import_*"}}, []string{"import_events", "import_users"}},
+		{"names are deduplicated and ordered", syncSelection{Groups: []string{"nightly"}, Patterns: []string{"refresh_stats.sql", "import_users"}},
+			[]string{"import_events", "import_users", "refresh_stats"}},
+		{"plain names pass through", syncSelection{Patterns: []string{"legacy"}}, []string{"legacy"}},
+	}
+	for _, c := range cases {
+		got, err := selectSyncFiles(c.sel)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: want %v, got %v", c.name, c.want, got)
+		}
+	}
+
+	for name, sel := range map[string]syncSelection{
+		"unknown group":   {Groups: []string{"monthly"}},
+		"unmatched glob":  {Patterns: []string{"export_*"}},
+		"invalid pattern": {Patterns: []string{"import_["}},
+	} {
+		if _, err := selectSyncFiles(sel); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestResolveSyncFile_LegacyLocation(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, "test_syncresolve.db", dir)
+	writeSyncFiles(t, dir, map[string]string{"old.sql": ""})
+	writeSyncFiles(t, filepath.Join(dir, "sync"), map[string]string{"new.sql": ""})
+
+	if path, err := resolveSyncFile("new"); err != nil || path != filepath.Join(dir, "sync", "new.sql") {
+		t.Errorf("sync directory: got %q, %v", path, err)
+	}
+	if path, err := resolveSyncFile("old"); err != nil || path != filepath.Join(dir, "old.sql") {
+		t.Errorf("migrations directory: got %q, %v", path, err)
+	}
+	if _, err := resolveSyncFile("missing"); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestSyncFiles_RunsAllAndSummarizes(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "test_syncall.db"), dir)
+	initialize()
+	writeSyncFiles(t, filepath.Join(dir, "sync"), map[string]string{
+		"a_ok.sql":     "-- MIGRATE\nCREATE TABLE a_ok (id INTEGER);",
+		"b_broken.sql": "-- MIGRATE\nSELECT * FROM no_such_table;",
+		"c_ok.sql":     "-- MIGRATE\nCREATE TABLE c_ok (id INTEGER);",
+	})
+
+	names, err := selectSyncFiles(syncSelection{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var code int
+	out := captureStdout(t, func() { code = syncFiles(names) })
+	if code != exitFailure {
+		t.Errorf("want exit %d when one file fails, got %d", exitFailure, code)
+	}
+	for _, want := range []string{"Sync summary:", "a_ok", "b_broken", statusFailed, "c_ok"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("summary should contain %q:\n%s", want, out)
+		}
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var ok, failed int
+	if err = db.QueryRow("SELECT count(*) FILTER (WHERE status = 'success'), count(*) FILTER (WHERE status = 'failed') FROM "+syncTableRef()).Scan(&ok, &failed); err != nil || ok != 2 || failed != 1 {
+		t.Errorf("want every run recorded, got %d successful and %d failed (%v)", ok, failed, err)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// syncStateTable keeps, in the tracking schema, the watermarks of
+// incremental sync files.
+const syncStateTable = "sync_state"
+
+// watermarkRe matches {{watermark "streams.curr_time"}}, optionally followed
+// by a default used until the watermark has a value:
+// {{watermark "streams.curr_time" "1970-01-01"}}.
+var watermarkRe = regexp.MustCompile(`\{\{\s*watermark\s+"([^"]*)"(?:\s+"([^"]*)")?\s*\}\}`)
+
+// syncStateTableSQL creates the sync state table in the tracking schema.
+func syncStateTableSQL() string {
+	return fmt.Sprintf(`
+CREATE SCHEMA IF NOT EXISTS attached_db.%[1]s;
+CREATE TABLE IF NOT EXISTS %[2]s (
+    filename VARCHAR NOT NULL,
+    watermark VARCHAR NOT NULL,
+    value VARCHAR,
+    updated_at TIMESTAMP,
+    run_id VARCHAR
+);
+`, quoteIdent(trackingSchema), trackingName(syncStateTable))
+}
+
+// checkWatermarkName returns an error unless name is [schema.]table.column,
+// the column whose maximum becomes the watermark after each run.
+func checkWatermarkName(name string) error {
+	parts := strings.Split(name, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("invalid watermark %q: use table.column or schema.table.column", name)
+	}
+	for _, p := range parts {
+		if !identRe.MatchString(p) {
+			return fmt.Errorf("invalid watermark %q: %q is not a plain identifier", name, p)
+		}
+	}
+	return nil
+}
+
+// watermarkNames returns the watermarks content refers to, in order of first
+// use.
+func watermarkNames(content string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, m := range watermarkRe.FindAllStringSubmatch(content, -1) {
+		if err := checkWatermarkName(m[1]); err != nil {
+			return nil, err
+		}
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names, nil
+}
+
+// expandWatermarks replaces watermark macros with the values in state, as
+// SQL literals. A watermark without a value becomes its default, or NULL.
+func expandWatermarks(content string, state map[string]string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range watermarkRe.FindAllStringSubmatchIndex(content, -1) {
+		b.WriteString(content[last:m[0]])
+		last = m[1]
+		name := content[m[2]:m[3]]
+		switch v, ok := state[name]; {
+		case ok:
+			b.WriteString(quoteLiteral(v))
+		case m[4] >= 0:
+			b.WriteString(quoteLiteral(content[m[4]:m[5]]))
+		default:
+			b.WriteString("NULL")
+		}
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// loadSyncState returns the watermarks stored for a sync file.
+func loadSyncState(db *sql.DB, migrationName string) (map[string]string, error) {
+	rows, err := db.QueryContext(rootCtx,
+		"SELECT watermark, value FROM "+trackingName(syncStateTable)+" WHERE filename = ? AND value IS NOT NULL", migrationName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	state := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err = rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		state[name] = value
+	}
+	return state, rows.Err()
+}
+
+// saveWatermarks advances each watermark to the maximum of its column, in
+// the sync run's transaction, so it only moves when the run commits. An
+// empty column leaves the watermark as it was.
+func saveWatermarks(tx *sql.Tx, migrationName string, names []string) error {
+	for _, name := range names {
+		parts := strings.Split(name, ".")
+		for i, p := range parts {
+			parts[i] = quoteIdent(p)
+		}
+		column, table := parts[len(parts)-1], strings.Join(parts[:len(parts)-1], ".")
+		var value sql.NullString
+		if err := tx.QueryRowContext(rootCtx, fmt.Sprintf("SELECT max(%s)::VARCHAR FROM %s", column, table)).Scan(&value); err != nil {
+			return fmt.Errorf("failed to read watermark %s: %v", name, err)
+		}
+		if !value.Valid {
+			continue
+		}
+		if err := setWatermark(tx, migrationName, name, value.String); err != nil {
+			return fmt.Errorf("failed to save watermark %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// setWatermark stores value as the watermark name of a sync file.
+func setWatermark(db execer, migrationName, name, value string) error {
+	table := trackingName(syncStateTable)
+	_, err := db.ExecContext(rootCtx, "DELETE FROM "+table+" WHERE filename = ? AND watermark = ?", migrationName, name)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(rootCtx,
+		"INSERT INTO "+table+" (filename, watermark, value, updated_at, run_id) VALUES (?, ?, ?, ?, ?)",
+		migrationName, name, value, time.Now().UTC(), runID)
+	return err
+}
+
+// syncStateCommand implements sync state show|set|reset.
+func syncStateCommand(args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "Usage: duckdbm sync state show [name] | set <name> <watermark> <value> | reset <name> [watermark]")
+		return exitUsage
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+	op, args := args[0], args[1:]
+	if len(args) > 0 {
+		args[0] = strings.TrimSuffix(args[0], ".sql")
+	}
+	switch {
+	case op == "show" && len(args) <= 1:
+		name := ""
+		if len(args) == 1 {
+			name = args[0]
+		}
+		return showSyncState(name)
+	case op == "set" && len(args) == 3:
+		if err := checkWatermarkName(args[1]); err != nil {
+			logger.Error("Failed to set the watermark", "error", err)
+			return exitUsage
+		}
+		return changeSyncState(func(db *sql.DB) error {
+			if err := setWatermark(db, args[0], args[1], args[2]); err != nil {
+				return err
+			}
+			logger.Info("Set the watermark", "migration", args[0], "watermark", args[1], "value", args[2])
+			return nil
+		})
+	case op == "reset" && (len(args) == 1 || len(args) == 2):
+		return changeSyncState(func(db *sql.DB) error {
+			query := "DELETE FROM " + trackingName(syncStateTable) + " WHERE filename = ?"
+			params := []any{args[0]}
+			if len(args) == 2 {
+				query += " AND watermark = ?"
+				params = append(params, args[1])
+			}
+			res, err := db.ExecContext(rootCtx, query, params...)
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			if n == 0 {
+				logger.Warn("No watermarks to reset", "migration", args[0])
+				return nil
+			}
+			logger.Info("Reset the watermarks; the next run starts from the default", "migration", args[0], "count", n)
+			return nil
+		})
+	}
+	return usage()
+}
+
+// changeSyncState runs change on a read-write connection once the sync
+// state table exists.
+func changeSyncState(change func(db *sql.DB) error) int {
+	db, err := connectDB()
+	if err != nil {
+		logger.Error("Failed to connect to the database", "error", err)
+		return connectionExitCode(err)
+	}
+	defer func(db *sql.DB) { _ = db.Close() }(db)
+
+	if ok, err := hasTable(db, trackingSchema, syncStateTable); err != nil {
+		logger.Error("Failed to check the sync state table", "error", err)
+		return exitFailure
+	} else if !ok {
+		logger.Error("Table not initialized. Run 'init' first.", "table", syncStateTable)
+		return exitFailure
+	}
+	if err = change(db); err != nil {
+		logger.Error("Failed to update the sync state", "error", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// showSyncState lists the watermarks of one sync file, or of all of them.
+func showSyncState(name string) int {
+	db, err := connectReadOnlyDB()
+	if err != nil {
+		logger.Error("Failed to connect to the database", "error", err)
+		return connectionExitCode(err)
+	}
+	defer func(db *sql.DB) { _ = db.Close() }(db)
+
+	if ok, err := hasTable(db, trackingSchema, syncStateTable); err != nil {
+		logger.Error("Failed to check the sync state table", "error", err)
+		return exitFailure
+	} else if !ok {
+		logger.Error("Table not initialized. Run 'init' first.", "table", syncStateTable)
+		return exitFailure
+	}
+
+	rows, err := db.QueryContext(rootCtx,
+		"SELECT filename, watermark, value, updated_at, run_id FROM "+trackingName(syncStateTable)+
+			" WHERE ? = '' OR filename = ? ORDER BY filename, watermark", name, name)
+	if err != nil {
+		logger.Error("Failed to fetch the sync state", "error", err)
+		return exitFailure
+	}
+	defer rows.Close()
+
+	out := outputTable{
+		Title: "Sync state:",
+		Columns: []outputColumn{
+			{Key: "filename", Header: "Filename"},
+			{Key: "watermark", Header: "Watermark"},
+			{Key: "value", Header: "Value"},
+			{Key: "updated_at", Header: "Updated At"},
+			{Key: "run_id", Header: "Run ID"},
+		},
+	}
+	for rows.Next() {
+		var filename, watermark string
+		var value, rid sql.NullString
+		var updatedAt sql.NullTime
+		if err = rows.Scan(&filename, &watermark, &value, &updatedAt, &rid); err != nil {
+			logger.Error("Failed to read the sync state", "error", err)
+			return exitFailure
+		}
+		var updated any
+		if updatedAt.Valid {
+			updated = updatedAt.Time
+		}
+		out.Rows = append(out.Rows, []any{filename, watermark, nullString(value), updated, nullString(rid)})
+	}
+	if err = rows.Err(); err != nil {
+		logger.Error("Failed to read the sync state", "error", err)
+		return exitFailure
+	}
+	if err = out.write(os.Stdout, outputFormat); err != nil {
+		logger.Error("Failed to write output", "error", err)
+		return exitFailure
+	}
+	return exitOK
+}
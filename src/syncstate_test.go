@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandWatermarks(t *testing.T) {
+	content := `SELECT {{watermark "streams.ts"}}, {{ watermark "streams.ts" "1970-01-01" }}, {{watermark "main.users.id" ""}};`
+	names, err := watermarkNames(content)
+	if err != nil || len(names) != 2 || names[0] != "streams.ts" || names[1] != "main.users.id" {
+		t.Fatalf("watermarkNames: got %v, %v", names, err)
+	}
+	if got, want := expandWatermarks(content, nil), `SELECT NULL, '1970-01-01', '';`; got != want {
+		t.Errorf("without state: want %q, got %q", want, got)
+	}
+	state := map[string]string{"streams.ts": "2025-05-24 10:00:00", "main.users.id": "O'Brien"}
+	if got, want := expandWatermarks(content, state), `SELECT '2025-05-24 10:00:00', '2025-05-24 10:00:00', 'O''Brien';`; got != want {
+		t.Errorf("with state: want %q, got %q", want, got)
+	}
+	for _, bad := range []string{`{{watermark "ts"}}`, `{{watermark "a.b.c.d"}}`, `{{watermark "streams.curr time"}}`} {
+		if _, err := processMacros(bad); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestSyncWatermarks(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "test_watermarks.db"), dir)
+	setOutputFormat(t, formatJSON)
+	initialize()
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE src (id INTEGER); INSERT INTO src VALUES (1), (2);
+CREATE TABLE streams (id INTEGER);`)
+	_ = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSyncFiles(t, filepath.Join(dir, "sync"), map[string]string{
+		"streams.sql": `-- MIGRATE
+INSERT INTO streams SELECT id FROM src WHERE id > {{watermark "streams.id" "0"}};`,
+		"broken.sql": `-- MIGRATE
+INSERT INTO streams VALUES (100);
+SELECT * FROM no_such_table;
+-- {{watermark "streams.id"}}`,
+	})
+
+	count := func() (n, maxID int) {
+		t.Helper()
+		db, err := connectDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if err = db.QueryRow("SELECT count(*), max(id) FROM streams").Scan(&n, &maxID); err != nil {
+			t.Fatal(err)
+		}
+		return n, maxID
+	}
+	state := func(name string) []map[string]any {
+		t.Helper()
+		var rows []map[string]any
+		out := captureStdout(t, func() { syncStateCommand([]string{"show", name}) })
+		if err := json.Unmarshal([]byte(out), &rows); err != nil {
+			t.Fatalf("sync state show: %q (%v)", out, err)
+		}
+		return rows
+	}
+
+	var code int
+	captureStdout(t, func() { code = syncMigration("streams") })
+	if code != exitOK {
+		t.Fatalf("first sync: exit %d", code)
+	}
+	if rows := state("streams"); len(rows) != 1 || rows[0]["watermark"] != "streams.id" || rows[0]["value"] != "2" {
+		t.Errorf("watermark after the first run: %v", rows)
+	}
+
+	db, err = connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO src VALUES (3)")
+	_ = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureStdout(t, func() { syncMigration("streams") })
+	if n, maxID := count(); n != 3 || maxID != 3 {
+		t.Errorf("the second run should only import the new row: %d rows, max %d", n, maxID)
+	}
+
+	captureStdout(t, func() { code = syncMigration("broken") })
+	if code != exitFailure {
+		t.Errorf("broken: want exit %d, got %d", exitFailure, code)
+	}
+	if rows := state("broken"); len(rows) != 0 {
+		t.Errorf("a failed run must not save watermarks: %v", rows)
+	}
+
+	// Backfill: move the watermark back and import again.
+	if code := syncStateCommand([]string{"set", "streams.sql", "streams.id", "1"}); code != exitOK {
+		t.Fatalf("sync state set: exit %d", code)
+	}
+	if rows := state("streams"); len(rows) != 1 || rows[0]["value"] != "1" {
+		t.Errorf("watermark after set: %v", rows)
+	}
+	if code := syncStateCommand([]string{"reset", "streams"}); code != exitOK {
+		t.Fatalf("sync state reset: exit %d", code)
+	}
+	if rows := state(""); len(rows) != 0 {
+		t.Errorf("state after reset: %v", rows)
+	}
+
+	for _, args := range [][]string{nil, {"show", "a", "b"}, {"set", "streams", "streams.id"}, {"set", "streams", "id", "1"}, {"drop", "streams"}} {
+		if code := syncStateCommand(args); code != exitUsage {
+			t.Errorf("%v: want exit %d, got %d", args, exitUsage, code)
+		}
+	}
+}
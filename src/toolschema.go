@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// metaTable records, in the tracking schema, which toolSchemaUpgrades have
+// run on a database.
+const metaTable = "duckdbm_meta"
+
+// toolSchemaUpgrade is one change to the layout of duckdbm's own tables.
+type toolSchemaUpgrade struct {
+	Version     int
+	Description string
+	SQL         func() string
+}
+
+// toolSchemaUpgrades lists every change to duckdbm's own tables, oldest
+// first. Databases created before metaTable existed run all of them, so each
+// step must be idempotent. Append new steps; never edit or renumber released
+// ones.
+var toolSchemaUpgrades = []toolSchemaUpgrade{
+	{1, "create the migrations and sync tables", func() string {
+		return migrationsTableSQL() + syncTableSQL()
+	}},
+	{2, "record DuckDB settings with migrations and sync runs", func() string {
+		return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS settings VARCHAR; ALTER TABLE %s ADD COLUMN IF NOT EXISTS settings VARCHAR;",
+			migrationsTableRef(), syncTableRef())
+	}},
+	{3, "record whether sync runs succeeded or were cancelled", func() string {
+		return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS status VARCHAR;", syncTableRef())
+	}},
+	{4, "record every sync run with its run ID, end time, error, row counts and host", func() string {
+		t := syncTableRef()
+		// Before status existed only successful runs were recorded.
+		return fmt.Sprintf(`ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS run_id VARCHAR;
+ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS finished_at TIMESTAMP;
+ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS error VARCHAR;
+ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS rows_affected VARCHAR;
+ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS host VARCHAR;
+UPDATE %[1]s SET status = '%[2]s' WHERE status IS NULL;`, t, statusSuccess)
+	}},
+	{5, "keep watermarks for incremental syncs", syncStateTableSQL},
+	{6, "number the attempts of retried sync runs", func() string {
+		return fmt.Sprintf("ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS attempt INTEGER; UPDATE %[1]s SET attempt = 1 WHERE attempt IS NULL;", syncTableRef())
+	}},
+}
+
+// latestToolSchemaVersion is the version this build of duckdbm upgrades to.
+func latestToolSchemaVersion() int {
+	return toolSchemaUpgrades[len(toolSchemaUpgrades)-1].Version
+}
+
+// toolSchemaVersion returns the last upgrade recorded in metaTable, or 0 if
+// the table does not exist.
+func toolSchemaVersion(db *sql.DB) (int, error) {
+	ok, err := hasTable(db, trackingSchema, metaTable)
+	if err != nil || !ok {
+		return 0, err
+	}
+	var v int
+	err = db.QueryRowContext(rootCtx, "SELECT coalesce(max(version), 0) FROM "+trackingName(metaTable)).Scan(&v)
+	return v, err
+}
+
+// checkToolSchema returns the database's tool-schema version and refuses
+// databases upgraded by a newer duckdbm, whose tables this build may
+// misread or damage.
+func checkToolSchema(db *sql.DB) (int, error) {
+	v, err := toolSchemaVersion(db)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the duckdbm tables version: %v", err)
+	}
+	if latest := latestToolSchemaVersion(); v > latest {
+		return v, fmt.Errorf("the duckdbm tables in this database are at version %d, but duckdbm %s only supports up to version %d; upgrade duckdbm", v, version, latest)
+	}
+	return v, nil
+}
+
+// upgradeToolSchema runs the toolSchemaUpgrades db has not had yet, each in
+// its own transaction. Unless create is set, a database without duckdbm's
+// tables is left alone: only init creates them.
+func upgradeToolSchema(db *sql.DB, create bool) error {
+	current, err := checkToolSchema(db)
+	if err != nil {
+		return err
+	}
+	latest := latestToolSchemaVersion()
+	if current == latest {
+		return nil
+	}
+	existing := current > 0
+	if !existing {
+		if existing, err = hasTable(db, trackingSchema, migrationsTable); err != nil {
+			return err
+		}
+		if !existing && !create {
+			return nil
+		}
+	}
+	if existing {
+		logger.Info("Upgrading duckdbm tables", "from", current, "to", latest)
+	}
+
+	if _, err = db.ExecContext(rootCtx, metaTableSQL()); err != nil {
+		return fmt.Errorf("failed to create %s: %v", metaTable, err)
+	}
+	for _, u := range toolSchemaUpgrades {
+		if u.Version <= current {
+			continue
+		}
+		_, err = runInTx(rootCtx, db, u.SQL(), func(tx *sql.Tx, _ int64) error {
+			_, err := tx.ExecContext(rootCtx,
+				"INSERT INTO "+trackingName(metaTable)+" (version, description, tool_version) VALUES (?, ?, ?)",
+				u.Version, u.Description, version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upgrade the duckdbm tables to version %d (%s): %v", u.Version, u.Description, err)
+		}
+		if existing {
+			logger.Info("Upgraded duckdbm tables", "version", u.Version, "description", u.Description)
+		}
+	}
+	return nil
+}
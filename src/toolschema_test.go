@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpgradeToolSchema_FreshInit(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "fresh.db"), dir)
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := hasTable(db, trackingSchema, metaTable); ok {
+		t.Error("connecting must not create duckdbm's tables before init")
+	}
+	_ = db.Close()
+
+	if code := initialize(); code != exitOK {
+		t.Fatalf("init: exit %d", code)
+	}
+	db, err = connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	v, err := toolSchemaVersion(db)
+	if err != nil || v != latestToolSchemaVersion() {
+		t.Errorf("version after init: want %d, got %d (%v)", latestToolSchemaVersion(), v, err)
+	}
+}
+
+func TestUpgradeToolSchema_UpgradesOldTables(t *testing.T) {
+	restoreLogger(t)
+	var logs bytes.Buffer
+	logger = slog.New(slog.NewTextHandler(&logs, nil))
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "old.db"), dir)
+
+	// The layout written by duckdbm before settings, status and the meta
+	// table existed.
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+CREATE SEQUENCE attached_db.seq_id START 1;
+CREATE TABLE attached_db.migrations (id INTEGER PRIMARY KEY DEFAULT nextval('attached_db.seq_id'), filename TEXT NOT NULL UNIQUE, applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, duration_ms INTEGER);
+CREATE SEQUENCE attached_db.seq_sync_id START 1;
+CREATE TABLE attached_db.sync (id INTEGER PRIMARY KEY DEFAULT nextval('attached_db.seq_sync_id'), filename TEXT NOT NULL, applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, duration_ms INTEGER);
+INSERT INTO attached_db.migrations (filename) VALUES ('001_old.sql');
+INSERT INTO attached_db.sync (filename) VALUES ('001_import');`)
+	_ = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = connectDB()
+	if err != nil {
+		t.Fatalf("connectDB on old tables: %v", err)
+	}
+	defer db.Close()
+	for _, c := range []struct{ table, column string }{{"migrations", "settings"}, {"sync", "settings"}, {"sync", "status"}, {"sync", "finished_at"}, {"sync", "rows_affected"}, {"sync", "attempt"}} {
+		if ok, _ := hasColumn(db, "main", c.table, c.column); !ok {
+			t.Errorf("%s.%s should have been added", c.table, c.column)
+		}
+	}
+	if v, _ := toolSchemaVersion(db); v != latestToolSchemaVersion() {
+		t.Errorf("version after upgrade: want %d, got %d", latestToolSchemaVersion(), v)
+	}
+	if !strings.Contains(logs.String(), "Upgrading duckdbm tables") {
+		t.Errorf("the upgrade should be logged, got %q", logs.String())
+	}
+	var n int
+	if err = db.QueryRow("SELECT count(*) FROM attached_db.migrations").Scan(&n); err != nil || n != 1 {
+		t.Errorf("rows must survive the upgrade: got %d (%v)", n, err)
+	}
+	var status string
+	if err = db.QueryRow("SELECT status FROM attached_db.sync").Scan(&status); err != nil || status != statusSuccess {
+		t.Errorf("sync runs from before statuses were recorded succeeded: got %q (%v)", status, err)
+	}
+}
+
+func TestCheckToolSchema_RefusesNewerVersion(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "newer.db"), dir)
+	initialize()
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO "+trackingName(metaTable)+" (version, description, tool_version) VALUES (?, 'from the future', '9.9.9')",
+		latestToolSchemaVersion()+1)
+	_ = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = connectDB(); err == nil || !strings.Contains(err.Error(), "upgrade duckdbm") {
+		t.Errorf("read-write connect: want a refusal, got %v", err)
+	}
+	if _, err = connectReadOnlyDB(); err == nil {
+		t.Error("read-only connect: want a refusal")
+	}
+	if code := showStatus(false); code != exitConnection {
+		t.Errorf("status: want exit %d, got %d", exitConnection, code)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Default location of duckdbm's own tables, used by every database created
+// before the location was configurable.
+const (
+	defaultTrackingSchema  = "main"
+	defaultMigrationsTable = "migrations"
+	defaultSyncTable       = "sync"
+
+	migrationsSequence = "seq_id"
+	syncSequence       = "seq_sync_id"
+)
+
+// Where duckdbm keeps its tables in the attached database, set by
+// tracking_schema, migrations_table and sync_table in duckdbm.yaml.
+var (
+	trackingSchema  = defaultTrackingSchema
+	migrationsTable = defaultMigrationsTable
+	syncTable       = defaultSyncTable
+)
+
+// identRe matches the schema and table names accepted for tracking tables.
+var identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// trackingName returns the qualified name of a table or sequence in the
+// tracking schema.
+func trackingName(name string) string {
+	return "attached_db." + quoteIdent(trackingSchema) + "." + quoteIdent(name)
+}
+
+func migrationsTableRef() string { return trackingName(migrationsTable) }
+
+func syncTableRef() string { return trackingName(syncTable) }
+
+// trackingTableFor maps the table argument of list to the configured table.
+func trackingTableFor(kind string) (string, bool) {
+	switch kind {
+	case "migrations":
+		return migrationsTable, true
+	case "sync":
+		return syncTable, true
+	}
+	return "", false
+}
+
+// moveTrackingTables moves the migrations and sync tables from their default
+// location to the configured schema and names, keeping their rows and ids.
+// It only acts while the old table exists and the new one does not, so it
+// runs once per database.
+func moveTrackingTables(db *sql.DB) error {
+	for _, t := range []struct {
+		legacy, sequence, name string
+		ddl                    func() string
+	}{
+		{defaultMigrationsTable, migrationsSequence, migrationsTable, migrationsTableSQL},
+		{defaultSyncTable, syncSequence, syncTable, syncTableSQL},
+	} {
+		if trackingSchema == defaultTrackingSchema && t.name == t.legacy {
+			continue
+		}
+		legacy, err := isLegacyTrackingTable(db, t.legacy)
+		if err != nil {
+			return err
+		}
+		if !legacy {
+			continue
+		}
+		exists, err := hasTable(db, trackingSchema, t.name)
+		if err != nil {
+			return err
+		}
+		from, to := defaultTrackingSchema+"."+t.legacy, trackingSchema+"."+t.name
+		if exists {
+			logger.Warn("Tracking table exists in both locations; leaving the old one in place", "from", from, "to", to)
+			continue
+		}
+		if err = moveTrackingTable(db, t.legacy, t.sequence, t.name, t.ddl); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %v", from, to, err)
+		}
+		logger.Info("Moved tracking table", "from", from, "to", to)
+	}
+	return moveFixedTables(db)
+}
+
+// moveFixedTables follows the tracking tables into a dedicated schema with
+// duckdbm's tables whose names are not configurable: the record of which
+// tool-schema upgrades have run and the sync watermarks.
+func moveFixedTables(db *sql.DB) error {
+	if trackingSchema == defaultTrackingSchema {
+		return nil
+	}
+	for _, t := range []struct {
+		name string
+		ddl  func() string
+	}{{metaTable, metaTableSQL}, {syncStateTable, syncStateTableSQL}} {
+		legacy, err := hasTable(db, defaultTrackingSchema, t.name)
+		if err != nil {
+			return err
+		}
+		if !legacy {
+			continue
+		}
+		if exists, err := hasTable(db, trackingSchema, t.name); err != nil {
+			return err
+		} else if exists {
+			continue
+		}
+		old := "attached_db." + quoteIdent(defaultTrackingSchema) + "." + quoteIdent(t.name)
+		_, err = runInTx(rootCtx, db, t.ddl(), func(tx *sql.Tx, _ int64) error {
+			if _, err := tx.ExecContext(rootCtx, "INSERT INTO "+trackingName(t.name)+" SELECT * FROM "+old); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(rootCtx, "DROP TABLE "+old)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to move %s.%s: %v", defaultTrackingSchema, t.name, err)
+		}
+	}
+	return nil
+}
+
+// isLegacyTrackingTable reports whether main.table exists and looks like a
+// table duckdbm created, rather than a user table of the same name.
+func isLegacyTrackingTable(db *sql.DB, table string) (bool, error) {
+	var n int
+	err := db.QueryRowContext(rootCtx,
+		`SELECT count(*) FROM duckdb_columns() WHERE database_name = 'attached_db' AND schema_name = ? AND table_name = ?
+			AND column_name IN ('id', 'filename', 'applied_at', 'duration_ms')`,
+		defaultTrackingSchema, table,
+	).Scan(&n)
+	return n == 4, err
+}
+
+func moveTrackingTable(db *sql.DB, legacy, sequence, name string, ddl func() string) error {
+	old := "attached_db." + quoteIdent(defaultTrackingSchema) + "." + quoteIdent(legacy)
+	tx, err := db.BeginTx(rootCtx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if trackingSchema == defaultTrackingSchema {
+		// Same schema: a rename keeps the rows and the sequence.
+		if _, err = tx.ExecContext(rootCtx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s", old, quoteIdent(name))); err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(rootCtx, ddl()); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	var next int64
+	if err = tx.QueryRowContext(rootCtx, "SELECT coalesce(max(id), 0) + 1 FROM "+old).Scan(&next); err != nil {
+		return err
+	}
+	setup := fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS attached_db.%s; CREATE SEQUENCE IF NOT EXISTS %s START %d;",
+		quoteIdent(trackingSchema), trackingName(sequence), next)
+	if _, err = tx.ExecContext(rootCtx, setup+ddl()); err != nil {
+		return err
+	}
+
+	// Copy the columns both tables have; old tables may predate some.
+	rows, err := tx.QueryContext(rootCtx,
+		`SELECT column_name FROM duckdb_columns() WHERE database_name = 'attached_db' AND schema_name = ? AND table_name = ?
+			AND column_name IN (SELECT column_name FROM duckdb_columns() WHERE database_name = 'attached_db' AND schema_name = ? AND table_name = ?)
+			ORDER BY column_index`,
+		defaultTrackingSchema, legacy, trackingSchema, name)
+	if err != nil {
+		return err
+	}
+	var columns []string
+	for rows.Next() {
+		var c string
+		if err = rows.Scan(&c); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		columns = append(columns, quoteIdent(c))
+	}
+	_ = rows.Close()
+	cols := strings.Join(columns, ", ")
+	if _, err = tx.ExecContext(rootCtx, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", trackingName(name), cols, cols, old)); err != nil {
+		return err
+	}
+	drop := fmt.Sprintf("DROP TABLE %s; DROP SEQUENCE IF EXISTS attached_db.%s.%s;", old, quoteIdent(defaultTrackingSchema), quoteIdent(sequence))
+	if _, err = tx.ExecContext(rootCtx, drop); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
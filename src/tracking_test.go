@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func setTrackingLocation(t *testing.T, schema, migrations, sync string) {
+	t.Helper()
+	prevSchema, prevMigrations, prevSync := trackingSchema, migrationsTable, syncTable
+	trackingSchema, migrationsTable, syncTable = schema, migrations, sync
+	t.Cleanup(func() { trackingSchema, migrationsTable, syncTable = prevSchema, prevMigrations, prevSync })
+}
+
+func TestMoveTrackingTables_ToDedicatedSchema(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "move.db"), dir)
+	setTrackingLocation(t, defaultTrackingSchema, defaultMigrationsTable, defaultSyncTable)
+	initialize()
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		"INSERT INTO attached_db.migrations (filename, duration_ms) VALUES ('001_a.sql', 1), ('002_b.sql', 2)",
+		"INSERT INTO attached_db.sync (filename, duration_ms, status) VALUES ('001_import', 3, 'success')",
+	} {
+		if _, err = db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = db.Close()
+
+	setTrackingLocation(t, "_duckdbm", "schema_migrations", "sync_runs")
+	db, err = connectDB()
+	if err != nil {
+		t.Fatalf("connectDB with a new tracking location: %v", err)
+	}
+	defer db.Close()
+
+	for _, table := range []string{"migrations", "sync", metaTable, syncStateTable} {
+		if ok, _ := hasTable(db, "main", table); ok {
+			t.Errorf("main.%s should have been moved", table)
+		}
+	}
+	if v, _ := toolSchemaVersion(db); v != latestToolSchemaVersion() {
+		t.Errorf("the upgrade history should move too: got version %d", v)
+	}
+	if _, err = db.Exec("INSERT INTO " + migrationsTableRef() + " (filename) VALUES ('003_c.sql')"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query("SELECT id, filename FROM " + migrationsTableRef() + " ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for rows.Next() {
+		var id int
+		var filename string
+		if err = rows.Scan(&id, &filename); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s#%d", filename, id))
+	}
+	_ = rows.Close()
+	if want := []string{"001_a.sql#1", "002_b.sql#2", "003_c.sql#3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("moved migrations: want %v, got %v", want, got)
+	}
+	var status string
+	if err = db.QueryRow("SELECT status FROM " + syncTableRef()).Scan(&status); err != nil || status != "success" {
+		t.Errorf("moved sync run: got %q, %v", status, err)
+	}
+
+	// The old names are free for user tables now.
+	if _, err = db.Exec("CREATE TABLE attached_db.main.sync (id INTEGER)"); err != nil {
+		t.Fatalf("create a user table named sync: %v", err)
+	}
+	objects, err := introspectSchema(db, "attached_db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Name != "sync" {
+		t.Errorf("dump should only contain the user table, got %v", objects)
+	}
+}
+
+func TestMoveTrackingTables_RenameInMain(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "rename.db"), dir)
+	setTrackingLocation(t, defaultTrackingSchema, defaultMigrationsTable, defaultSyncTable)
+	initialize()
+
+	setTrackingLocation(t, defaultTrackingSchema, "schema_migrations", defaultSyncTable)
+	if err := os.WriteFile(filepath.Join(dir, "001_a.sql"), []byte("-- MIGRATE\nCREATE TABLE a (id INTEGER);\n-- ROLLBACK\nDROP TABLE a;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := applyMigrations(); code != exitOK {
+		t.Fatalf("apply after the rename: exit %d", code)
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if ok, _ := hasTable(db, "main", "migrations"); ok {
+		t.Error("main.migrations should have been renamed")
+	}
+	var n int
+	if err = db.QueryRow("SELECT count(*) FROM " + migrationsTableRef()).Scan(&n); err != nil || n != 1 {
+		t.Errorf("schema_migrations: want 1 row, got %d (%v)", n, err)
+	}
+}
+
+func TestMoveTrackingTables_LeavesUserTablesAlone(t *testing.T) {
+	dir := t.TempDir()
+	resetGlobals(t, filepath.Join(dir, "user.db"), dir)
+	setTrackingLocation(t, "_duckdbm", defaultMigrationsTable, defaultSyncTable)
+
+	db, err := connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("CREATE TABLE attached_db.main.sync (id INTEGER, payload VARCHAR)"); err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+	if code := initialize(); code != exitOK {
+		t.Fatalf("init: exit %d", code)
+	}
+
+	db, err = connectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, c := range []struct{ schema, table string }{{"main", "sync"}, {"_duckdbm", "sync"}, {"_duckdbm", "migrations"}} {
+		if ok, _ := hasTable(db, c.schema, c.table); !ok {
+			t.Errorf("%s.%s should exist", c.schema, c.table)
+		}
+	}
+}
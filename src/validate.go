@@ -2,102 +2,460 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 
 	_ "github.com/duckdb/duckdb-go/v2"
 )
 
-func validateMigrations(args []string, dir string) {
-	db, err := sql.Open("duckdb", "")
-	if err != nil {
-		fmt.Printf("Failed to open validation database: %v\n", err)
-		os.Exit(1)
+// Validation checks, selected with validate flags.
+const (
+	checkSyntax    = "syntax"
+	checkApply     = "apply"
+	checkRoundtrip = "roundtrip"
+)
+
+// validateSkipDirective marks a statement that validate --apply must not run,
+// e.g. one that needs an extension or external source.
+const validateSkipDirective = "VALIDATE SKIP"
+
+// validationResult is the outcome of checking one file. A file can have
+// several results when more than one of its statements fails.
+type validationResult struct {
+	Check   string
+	Dir     string
+	File    string
+	Status  string // "ok", "error" or "skipped"
+	Message string
+	// Line is the line of the failing statement, 0 when unknown.
+	Line int
+}
+
+// statementError is an error in one statement of a section.
+type statementError struct {
+	Index int // 1-based position within the section
+	Line  int
+	Err   error
+}
+
+func (e *statementError) Error() string {
+	return fmt.Sprintf("statement %d (line %d): %v", e.Index, e.Line, e.Err)
+}
+
+// errorLine returns the statement line carried by err, or 0.
+func errorLine(err error) int {
+	var se *statementError
+	if errors.As(err, &se) {
+		return se.Line
 	}
-	defer func() { _ = db.Close() }()
+	return 0
+}
+
+// validateMigrations checks the SQL syntax of the migrations in each dir.
+func validateMigrations(args []string, dirs ...string) int {
+	return runValidation(args, []string{checkSyntax}, dirs)
+}
 
+// runValidation runs checks and reports the results in outputFormat. The
+// syntax check covers every dir; apply and roundtrip need ordered migrations
+// and only cover the first. A JUnit report is written when requested with
+// --report. It returns exitFailure if any file fails.
+func runValidation(args []string, checks []string, dirs []string) int {
 	var target string
 	if len(args) > 1 {
 		target = args[1]
 	}
 
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		fmt.Printf("Failed to read migrations directory: %v\n", err)
-		os.Exit(1)
+	type run struct {
+		check, dir string
+	}
+	var runs []run
+	for _, check := range checks {
+		if check != checkSyntax {
+			runs = append(runs, run{check, dirs[0]})
+			continue
+		}
+		for _, dir := range dirs {
+			runs = append(runs, run{check, dir})
+		}
+	}
+
+	var results []validationResult
+	for _, r := range runs {
+		var checked []validationResult
+		var err error
+		switch r.check {
+		case checkSyntax:
+			checked, err = checkSyntaxDir(r.dir, target)
+		case checkApply:
+			checked, err = checkApplyDir(r.dir, target)
+		case checkRoundtrip:
+			checked, err = checkRoundtripDir(r.dir, target)
+		}
+		if err != nil {
+			logger.Error("Failed to validate", "dir", r.dir, "error", err)
+			return exitFailure
+		}
+		if outputFormat == formatTable {
+			printValidationResults(r.check, r.dir, checked)
+		}
+		results = append(results, checked...)
 	}
-	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
 
 	hasErrors := false
-	fmt.Println("Validating migrations... " + dir)
+	for _, r := range results {
+		if r.Status == "error" {
+			hasErrors = true
+		}
+	}
 
-	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), ".sql") {
-			continue
+	if path := validateReports.path(reportJUnit); path != "" {
+		if err := writeJUnitReport(path, results); err != nil {
+			logger.Error("Failed to write JUnit report", "path", path, "error", err)
+			return exitFailure
+		}
+	}
+
+	if outputFormat != formatTable {
+		out := outputTable{Columns: []outputColumn{
+			{Key: "check", Header: "Check"},
+			{Key: "dir", Header: "Directory"},
+			{Key: "file", Header: "File"},
+			{Key: "status", Header: "Status"},
+			{Key: "message", Header: "Message"},
+		}}
+		for _, r := range results {
+			out.Rows = append(out.Rows, []any{r.Check, r.Dir, r.File, r.Status, r.Message})
+		}
+		if err := out.write(os.Stdout, outputFormat); err != nil {
+			logger.Error("Failed to write output", "error", err)
+			return exitFailure
+		}
+	} else if hasErrors {
+		fmt.Println("\nValidation failed.")
+	} else {
+		fmt.Println("\nAll migrations are valid.")
+	}
+
+	if hasErrors {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// printValidationResults prints the human-readable form of one check.
+func printValidationResults(check, dir string, results []validationResult) {
+	switch check {
+	case checkApply:
+		fmt.Println("Applying migrations to a scratch database... " + dir)
+	case checkRoundtrip:
+		fmt.Println("Checking rollback round trips... " + dir)
+	default:
+		fmt.Println("Validating migrations... " + dir)
+	}
+	for _, r := range results {
+		switch {
+		case r.Status == "error":
+			fmt.Printf("  ✗ %s — %s\n", r.File, r.Message)
+		case r.Status == "skipped":
+			fmt.Printf("  - %s — %s\n", r.File, r.Message)
+		case r.Message != "":
+			fmt.Printf("  ✓ %s (%s)\n", r.File, r.Message)
+		default:
+			fmt.Printf("  ✓ %s\n", r.File)
 		}
-		if target != "" && !strings.Contains(file.Name(), target) {
+	}
+}
+
+// checkSyntaxDir runs every statement of each file through EXPLAIN and
+// reports parser errors only.
+func checkSyntaxDir(dir, target string) ([]validationResult, error) {
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open validation database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	files, err := listMigrationFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %v", err)
+	}
+
+	var results []validationResult
+	result := func(file, status, message string, line int) {
+		results = append(results, validationResult{checkSyntax, dir, file, status, message, line})
+	}
+
+	for _, filename := range files {
+		if target != "" && !strings.Contains(filename, target) {
 			continue
 		}
 
-		sqlContent, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		sqlContent, err := os.ReadFile(filepath.Join(dir, filename))
 		if err != nil {
-			fmt.Printf("  ✗ %s — failed to read: %v\n", file.Name(), err)
-			hasErrors = true
+			result(filename, "error", fmt.Sprintf("failed to read: %v", err), 0)
 			continue
 		}
 
 		processed, err := processMacros(string(sqlContent))
 		if err != nil {
-			fmt.Printf("  ✗ %s — macro error: %v\n", file.Name(), err)
-			hasErrors = true
+			result(filename, "error", fmt.Sprintf("macro error: %v", err), 0)
 			continue
 		}
 
-		parts := strings.Split(processed, "-- ROLLBACK")
-		migrateSQL := strings.TrimSpace(strings.TrimPrefix(parts[0], "-- MIGRATE"))
+		parts := strings.SplitN(processed, "-- ROLLBACK", 2)
 
-		if verr := validateSection(db, migrateSQL); verr != nil {
-			fmt.Printf("  ✗ %s — %v\n", file.Name(), verr)
-			hasErrors = true
+		if verr := validateSection(db, parts[0], 1); verr != nil {
+			result(filename, "error", verr.Error(), errorLine(verr))
 			continue
 		}
 
 		if len(parts) > 1 {
-			rollbackSQL := strings.TrimSpace(parts[1])
-			if rollbackSQL != "" {
-				if verr := validateSection(db, rollbackSQL); verr != nil {
-					fmt.Printf("  ✗ %s (ROLLBACK) — %v\n", file.Name(), verr)
-					hasErrors = true
-					continue
-				}
+			if verr := validateSection(db, parts[1], strings.Count(parts[0], "\n")+1); verr != nil {
+				result(filename, "error", fmt.Sprintf("ROLLBACK section: %v", verr), errorLine(verr))
+				continue
 			}
 		}
 
-		fmt.Printf("  ✓ %s\n", file.Name())
+		result(filename, "ok", "", 0)
 	}
+	return results, nil
+}
 
-	if hasErrors {
-		fmt.Println("\nValidation failed.")
-		os.Exit(1)
+// checkApplyDir applies each migration in order to a scratch database. Files
+// after the first failing one are not checked, since they build on it.
+func checkApplyDir(dir, target string) ([]validationResult, error) {
+	db, err := openScratchDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open validation database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	files, err := listMigrationFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %v", err)
+	}
+
+	var results []validationResult
+	result := func(file, status, message string, line int) {
+		results = append(results, validationResult{checkApply, dir, file, status, message, line})
+	}
+
+	failed := ""
+	for _, filename := range files {
+		if failed != "" {
+			result(filename, "skipped", "not checked, depends on "+failed, 0)
+			continue
+		}
+
+		sqlContent, err := os.ReadFile(filepath.Join(dir, filename))
+		if err != nil {
+			result(filename, "error", fmt.Sprintf("failed to read: %v", err), 0)
+			failed = filename
+			continue
+		}
+		processed, err := processMacros(string(sqlContent))
+		if err != nil {
+			result(filename, "error", fmt.Sprintf("macro error: %v", err), 0)
+			failed = filename
+			continue
+		}
+
+		errs, skipped := applySection(db, strings.Split(processed, "-- ROLLBACK")[0], 1)
+		// Files not matching the target are still applied, since later files
+		// depend on them, but only matching files are reported.
+		if target != "" && !strings.Contains(filename, target) {
+			if len(errs) > 0 {
+				failed = filename
+			}
+			continue
+		}
+		if len(errs) > 0 {
+			for _, e := range errs {
+				result(filename, "error", e.Error(), errorLine(e))
+			}
+			failed = filename
+			continue
+		}
+		if skipped > 0 {
+			result(filename, "ok", fmt.Sprintf("%d statements skipped", skipped), 0)
+			continue
+		}
+		result(filename, "ok", "", 0)
+	}
+	return results, nil
+}
+
+// checkRoundtripDir checks every migration's ROLLBACK in a scratch database:
+// it applies the migration, rolls it back, compares the catalog with the state
+// before the migration and applies it again. A migration whose rollback is
+// missing, fails or leaves residue is reported.
+func checkRoundtripDir(dir, target string) ([]validationResult, error) {
+	files, err := listMigrationFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %v", err)
+	}
+
+	db, err := openScratchDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open validation database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	var results []validationResult
+	result := func(file, status, message string, line int) {
+		results = append(results, validationResult{checkRoundtrip, dir, file, status, message, line})
+	}
+
+	for i, filename := range files {
+		sqlContent, err := os.ReadFile(filepath.Join(dir, filename))
+		if err != nil {
+			result(filename, "error", fmt.Sprintf("failed to read: %v", err), 0)
+			break
+		}
+		processed, err := processMacros(string(sqlContent))
+		if err != nil {
+			result(filename, "error", fmt.Sprintf("macro error: %v", err), 0)
+			break
+		}
+		parts := strings.SplitN(processed, "-- ROLLBACK", 2)
+		checked := target == "" || strings.Contains(filename, target)
+
+		before, err := introspectSchema(db, "attached_db")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read scratch catalog: %v", err)
+		}
+		if errs, _ := applySection(db, parts[0], 1); len(errs) > 0 {
+			result(filename, "error", fmt.Sprintf("migration fails: %v", errs[0]), errorLine(errs[0]))
+			break
+		}
+		if !checked {
+			continue
+		}
+
+		if len(parts) < 2 || len(splitStatements(parts[1])) == 0 {
+			result(filename, "error", "no ROLLBACK section", 0)
+			continue
+		}
+
+		problem, line := "", 0
+		if errs, _ := applySection(db, parts[1], strings.Count(parts[0], "\n")+1); len(errs) > 0 {
+			problem, line = fmt.Sprintf("rollback fails: %v", errs[0]), errorLine(errs[0])
+		} else if after, err := introspectSchema(db, "attached_db"); err != nil {
+			problem = fmt.Sprintf("failed to read catalog after rollback: %v", err)
+		} else if residue := compareSnapshots(before, after); len(residue) > 0 {
+			problem = "rollback leaves residue: " + strings.Join(residue, "; ")
+		} else if errs, _ := applySection(db, parts[0], 1); len(errs) > 0 {
+			problem, line = fmt.Sprintf("re-apply after rollback fails: %v", errs[0]), errorLine(errs[0])
+		}
+
+		if problem == "" {
+			result(filename, "ok", "", 0)
+			continue
+		}
+		result(filename, "error", problem, line)
+
+		// The scratch database is in an unknown state; rebuild it so later
+		// migrations are checked against what apply would produce.
+		_ = db.Close()
+		if db, err = buildScratchDB(dir, files[:i+1]); err != nil {
+			return nil, fmt.Errorf("failed to rebuild scratch database: %v", err)
+		}
 	}
-	fmt.Println("\nAll migrations are valid.")
+	return results, nil
 }
 
-func validateSection(db *sql.DB, section string) error {
-	for _, stmt := range strings.Split(section, ";") {
-		stmt = strings.TrimSpace(stmt)
-		if stmt == "" || strings.HasPrefix(stmt, "--") {
+// buildScratchDB returns a scratch database with the MIGRATE section of each
+// file applied, honoring VALIDATE SKIP directives.
+func buildScratchDB(dir string, files []string) (*sql.DB, error) {
+	db, err := openScratchDB()
+	if err != nil {
+		return nil, err
+	}
+	for _, filename := range files {
+		sqlContent, err := os.ReadFile(filepath.Join(dir, filename))
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		processed, err := processMacros(string(sqlContent))
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		if errs, _ := applySection(db, strings.Split(processed, "-- ROLLBACK")[0], 1); len(errs) > 0 {
+			_ = db.Close()
+			return nil, fmt.Errorf("%s: %v", filename, errs[0])
+		}
+	}
+	return db, nil
+}
+
+// compareSnapshots describes how the catalog in after differs from before.
+func compareSnapshots(before, after []schemaObject) []string {
+	key := func(obj schemaObject) string { return obj.Kind + " " + qualifiedName(obj.Schema, obj.Name) }
+	normalize := func(obj schemaObject) string {
+		if obj.Kind == "sequence" {
+			return sequenceStartRe.ReplaceAllString(obj.SQL, "")
+		}
+		return obj.SQL
+	}
+
+	prev := make(map[string]schemaObject, len(before))
+	for _, obj := range before {
+		prev[key(obj)] = obj
+	}
+	var diffs []string
+	seen := make(map[string]bool, len(after))
+	for _, obj := range after {
+		k := key(obj)
+		seen[k] = true
+		old, ok := prev[k]
+		if !ok {
+			diffs = append(diffs, k+" left behind")
+		} else if normalize(old) != normalize(obj) {
+			diffs = append(diffs, k+" not restored")
+		}
+	}
+	for _, obj := range before {
+		if k := key(obj); !seen[k] {
+			diffs = append(diffs, k+" missing")
+		}
+	}
+	return diffs
+}
+
+// applySection executes each statement of section, continuing after errors so
+// every failing statement is reported. firstLine is the file line the section
+// starts on. It returns the errors and the number of skipped statements.
+func applySection(db *sql.DB, section string, firstLine int) ([]error, int) {
+	var errs []error
+	skipped := 0
+	for i, stmt := range splitStatementLines(section, firstLine) {
+		if hasDirective(stmt.SQL, validateSkipDirective) {
+			skipped++
 			continue
 		}
-		if _, err := db.Exec("EXPLAIN " + stmt); err != nil {
+		if _, err := db.ExecContext(rootCtx, stmt.SQL); err != nil {
+			errs = append(errs, &statementError{Index: i + 1, Line: stmt.Line, Err: err})
+		}
+	}
+	return errs, skipped
+}
+
+// validateSection runs each statement of section through EXPLAIN and returns
+// the first parser error. firstLine is the line section starts on.
+func validateSection(db *sql.DB, section string, firstLine int) error {
+	for i, stmt := range splitStatementLines(section, firstLine) {
+		if _, err := db.ExecContext(rootCtx, "EXPLAIN "+stmt.SQL); err != nil {
 			msg := err.Error()
 			if strings.Contains(msg, "Parser Error") ||
 				strings.Contains(msg, "syntax error") ||
 				strings.Contains(msg, "unexpected token") {
-				return fmt.Errorf("syntax error: %v", err)
+				return &statementError{Index: i + 1, Line: stmt.Line, Err: fmt.Errorf("syntax error: %v", err)}
 			}
 		}
 	}
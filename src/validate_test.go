@@ -2,8 +2,10 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	_ "github.com/duckdb/duckdb-go/v2"
@@ -26,7 +28,7 @@ func openMemDB(t *testing.T) *sql.DB {
 
 func TestValidateSection_ValidSelect(t *testing.T) {
 	db := openMemDB(t)
-	if err := validateSection(db, "SELECT 1"); err != nil {
+	if err := validateSection(db, "SELECT 1", 1); err != nil {
 		t.Errorf("expected no error for valid SELECT, got: %v", err)
 	}
 }
@@ -34,14 +36,14 @@ func TestValidateSection_ValidSelect(t *testing.T) {
 func TestValidateSection_MultipleStatements(t *testing.T) {
 	db := openMemDB(t)
 	section := "SELECT 1;\nSELECT 2;\nSELECT 3"
-	if err := validateSection(db, section); err != nil {
+	if err := validateSection(db, section, 1); err != nil {
 		t.Errorf("expected no error for multiple valid statements, got: %v", err)
 	}
 }
 
 func TestValidateSection_SyntaxError(t *testing.T) {
 	db := openMemDB(t)
-	if err := validateSection(db, "SELEKT * FRMO nowhere !!!"); err == nil {
+	if err := validateSection(db, "SELEKT * FRMO nowhere !!!", 1); err == nil {
 		t.Error("expected error for syntax error, got nil")
 	}
 }
@@ -49,7 +51,7 @@ func TestValidateSection_SyntaxError(t *testing.T) {
 func TestValidateSection_RuntimeErrorIgnored(t *testing.T) {
 	db := openMemDB(t)
 	// "table not found" is a runtime error — validation must not flag it
-	if err := validateSection(db, "SELECT * FROM nonexistent_table_xyz"); err != nil {
+	if err := validateSection(db, "SELECT * FROM nonexistent_table_xyz", 1); err != nil {
 		t.Errorf("expected runtime error to be ignored, got: %v", err)
 	}
 }
@@ -57,7 +59,7 @@ func TestValidateSection_RuntimeErrorIgnored(t *testing.T) {
 func TestValidateSection_EmptySection(t *testing.T) {
 	db := openMemDB(t)
 	for _, s := range []string{"", "   ", "\n\t"} {
-		if err := validateSection(db, s); err != nil {
+		if err := validateSection(db, s, 1); err != nil {
 			t.Errorf("empty section %q: expected no error, got: %v", s, err)
 		}
 	}
@@ -65,7 +67,7 @@ func TestValidateSection_EmptySection(t *testing.T) {
 
 func TestValidateSection_CommentOnly(t *testing.T) {
 	db := openMemDB(t)
-	if err := validateSection(db, "-- this is just a comment"); err != nil {
+	if err := validateSection(db, "-- this is just a comment", 1); err != nil {
 		t.Errorf("comment-only section: expected no error, got: %v", err)
 	}
 }
@@ -73,7 +75,7 @@ func TestValidateSection_CommentOnly(t *testing.T) {
 func TestValidateSection_MixedValidAndComments(t *testing.T) {
 	db := openMemDB(t)
 	section := "-- setup\nSELECT 1;\n-- done"
-	if err := validateSection(db, section); err != nil {
+	if err := validateSection(db, section, 1); err != nil {
 		t.Errorf("mixed comments/SQL: expected no error, got: %v", err)
 	}
 }
@@ -143,3 +145,158 @@ func TestValidateMigrations_IgnoresNonSQLFiles(t *testing.T) {
 
 	validateMigrations([]string{"validate"}, migrationsDir)
 }
+
+func TestApplySection_ReportsBinderErrorsWithLines(t *testing.T) {
+	db := openMemDB(t)
+	section := "-- MIGRATE\nCREATE TABLE a (id INTEGER);\nSELECT missing_col FROM a;\nINSERT INTO nowhere VALUES (1);\n"
+
+	errs, skipped := applySection(db, section, 1)
+	if skipped != 0 {
+		t.Errorf("expected no skipped statements, got %d", skipped)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(errs), errs)
+	}
+	if !strings.Contains(errs[0].Error(), "statement 2 (line 3)") {
+		t.Errorf("first error should point at statement 2, line 3: %v", errs[0])
+	}
+	if !strings.Contains(errs[1].Error(), "statement 3 (line 4)") {
+		t.Errorf("second error should point at statement 3, line 4: %v", errs[1])
+	}
+}
+
+func TestApplySection_SkipDirective(t *testing.T) {
+	db := openMemDB(t)
+	section := "-- VALIDATE SKIP\nATTACH 'database=prod' AS mysql_db (TYPE MYSQL);\nSELECT 1;"
+
+	errs, skipped := applySection(db, section, 1)
+	if len(errs) != 0 {
+		t.Errorf("expected skipped statement not to run, got %v", errs)
+	}
+	if skipped != 1 {
+		t.Errorf("expected 1 skipped statement, got %d", skipped)
+	}
+}
+
+func TestValidateByApplying_AllValid(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"001_users.sql": "-- MIGRATE\nCREATE TABLE users (id INTEGER);\n-- ROLLBACK\nDROP TABLE users;\n",
+		"002_email.sql": "-- MIGRATE\nALTER TABLE users ADD COLUMN email TEXT;\n-- ROLLBACK\nALTER TABLE users DROP COLUMN email;\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	// The second file depends on the first.
+	if code := runValidation([]string{"validate"}, []string{checkApply}, []string{dir}); code != exitOK {
+		t.Errorf("validate --apply: want exit %d, got %d", exitOK, code)
+	}
+}
+
+func TestCompareSnapshots_DetectsResidue(t *testing.T) {
+	db, err := openScratchDB()
+	if err != nil {
+		t.Fatalf("openScratchDB: %v", err)
+	}
+	defer db.Close()
+
+	if _, err = db.Exec("CREATE TABLE kept (id INTEGER); CREATE TABLE gone (id INTEGER);"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	before, err := introspectSchema(db, "attached_db")
+	if err != nil {
+		t.Fatalf("introspectSchema: %v", err)
+	}
+
+	// A migration adds a column and a sequence; its rollback drops the
+	// wrong table and forgets the sequence.
+	_, err = db.Exec("ALTER TABLE kept ADD COLUMN extra TEXT; CREATE SEQUENCE seq_residue; DROP TABLE gone;")
+	if err != nil {
+		t.Fatalf("mutate: %v", err)
+	}
+	after, err := introspectSchema(db, "attached_db")
+	if err != nil {
+		t.Fatalf("introspectSchema: %v", err)
+	}
+
+	got := strings.Join(compareSnapshots(before, after), "; ")
+	for _, want := range []string{`sequence "seq_residue" left behind`, `table "kept" not restored`, `table "gone" missing`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in %q", want, got)
+		}
+	}
+	if diffs := compareSnapshots(before, before); len(diffs) != 0 {
+		t.Errorf("identical snapshots should not differ: %v", diffs)
+	}
+}
+
+func TestBuildScratchDB_AppliesFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "001_users.sql"),
+		[]byte("-- MIGRATE\n-- VALIDATE SKIP\nINSTALL nonexistent_ext;\nCREATE TABLE users (id INTEGER);\n-- ROLLBACK\nDROP TABLE users;\n"), 0644); err != nil {
+		t.Fatalf("write migration: %v", err)
+	}
+
+	db, err := buildScratchDB(dir, []string{"001_users.sql"})
+	if err != nil {
+		t.Fatalf("buildScratchDB: %v", err)
+	}
+	defer db.Close()
+
+	var n int
+	if err = db.QueryRow("SELECT COUNT(*) FROM users").Scan(&n); err != nil {
+		t.Errorf("users table missing: %v", err)
+	}
+}
+
+func TestValidateRoundtrip_AllValid(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"001_users.sql": "-- MIGRATE\nCREATE TABLE users (id INTEGER);\n-- ROLLBACK\nDROP TABLE users;\n",
+		"002_email.sql": "-- MIGRATE\nALTER TABLE users ADD COLUMN email TEXT;\nCREATE SEQUENCE seq_email;\n-- ROLLBACK\nDROP SEQUENCE seq_email;\nALTER TABLE users DROP COLUMN email;\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	if code := runValidation([]string{"validate"}, []string{checkRoundtrip}, []string{dir}); code != exitOK {
+		t.Errorf("validate --roundtrip: want exit %d, got %d", exitOK, code)
+	}
+}
+
+func TestValidateMigrations_JSONOutput(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "001_ok.sql"), []byte("-- MIGRATE\nCREATE TABLE a (id INTEGER);\n-- ROLLBACK\nDROP TABLE a;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setOutputFormat(t, formatJSON)
+
+	out := captureStdout(t, func() { validateMigrations([]string{"validate"}, dir) })
+	var records []map[string]any
+	if err := json.Unmarshal([]byte(out), &records); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, out)
+	}
+	if len(records) != 1 || records[0]["file"] != "001_ok.sql" || records[0]["status"] != "ok" || records[0]["check"] != checkSyntax {
+		t.Errorf("unexpected records: %v", records)
+	}
+}
+
+func TestCheckSyntaxDir_ReportsLine(t *testing.T) {
+	dir := t.TempDir()
+	content := "-- MIGRATE\nCREATE TABLE a (id INTEGER);\nSELEKT 1;\n-- ROLLBACK\nDROP TABLE a;"
+	if err := os.WriteFile(filepath.Join(dir, "001_bad.sql"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, err := checkSyntaxDir(dir, "")
+	if err != nil {
+		t.Fatalf("checkSyntaxDir: %v", err)
+	}
+	if len(results) != 1 || results[0].Status != "error" || results[0].Line != 3 {
+		t.Errorf("expected an error on line 3, got %+v", results)
+	}
+}
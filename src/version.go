@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+const duckdbModule = "github.com/duckdb/duckdb-go/v2"
+
+// printVersion prints the version and whatever build information the binary
+// carries: Go version, VCS revision and the DuckDB driver version.
+func printVersion(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "duckdbm %s\n", version)
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "go:       %s\n", info.GoVersion)
+	settings := map[string]string{}
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	if rev := settings["vcs.revision"]; rev != "" {
+		if settings["vcs.modified"] == "true" {
+			rev += " (modified)"
+		}
+		_, _ = fmt.Fprintf(w, "revision: %s\n", rev)
+	}
+	if t := settings["vcs.time"]; t != "" {
+		_, _ = fmt.Fprintf(w, "built:    %s\n", t)
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == duckdbModule {
+			_, _ = fmt.Fprintf(w, "duckdb:   %s %s\n", dep.Path, dep.Version)
+		}
+	}
+}
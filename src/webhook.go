@@ -3,9 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"os"
 	"time"
 )
 
@@ -16,11 +14,11 @@ type webhookPayload struct {
 	DurationMs int64  `json:"duration_ms"`
 	Timestamp  string `json:"timestamp"`
 	Error      string `json:"error"`
+	Outcome    string `json:"outcome,omitempty"`
 }
 
-func sendWebhook(event, status, name string, durationMs int64, errMsg string) {
-	url := os.Getenv("WEBHOOK_URL")
-	if url == "" {
+func sendWebhook(event, status, outcome, name string, durationMs int64, errMsg string) {
+	if len(webhookURLs) == 0 {
 		return
 	}
 	payload := webhookPayload{
@@ -30,17 +28,20 @@ func sendWebhook(event, status, name string, durationMs int64, errMsg string) {
 		DurationMs: durationMs,
 		Timestamp:  time.Now().UTC().Format(time.RFC3339),
 		Error:      errMsg,
+		Outcome:    outcome,
 	}
 	body, err := json.Marshal(payload)
 	if err != nil {
-		fmt.Printf("Warning: webhook marshal failed: %v\n", err)
+		logger.Warn("Webhook marshal failed", "error", err)
 		return
 	}
 	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
-	if err != nil {
-		fmt.Printf("Warning: webhook delivery failed: %v\n", err)
-		return
+	for _, url := range webhookURLs {
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			logger.Warn("Webhook delivery failed", "url", url, "error", err)
+			continue
+		}
+		_ = resp.Body.Close()
 	}
-	defer func() { _ = resp.Body.Close() }()
 }